@@ -0,0 +1,72 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+)
+
+type (
+	WorkerConfig struct {
+		Emulator EmulatorConfig `json:"emulator"`
+		Video    VideoConfig    `json:"video"`
+	}
+
+	EmulatorConfig struct {
+		// Pacing is what frames are paced by: video runs them at the core's fps,
+		// audio keeps the produced audio on the wall clock so that it never starves nor piles up
+		Pacing string `json:"pacing"`
+	}
+
+	// VideoConfig limits the stream quality clients may ask for
+	VideoConfig struct {
+		MaxWidth   int      `json:"max_width"`
+		MaxHeight  int      `json:"max_height"`
+		MaxBitrate int      `json:"max_bitrate"` // bits per second
+		MinCRF     int      `json:"min_crf"`     // lower crf means higher quality and bitrate
+		Codecs     []string `json:"codecs"`      // codecs clients may use, in order of preference
+		// ffmpeg encoders tried in order for the negotiated codec, the next one is used
+		// when an encoder is missing from the ffmpeg build or fails to open
+		Encoders []string `json:"encoders"`
+	}
+)
+
+const (
+	WORKER_CONFIG_PATH = "./config/worker.json"
+)
+
+func DefaultWorkerConfig() WorkerConfig {
+	return WorkerConfig{
+		Emulator: EmulatorConfig{
+			Pacing: "video",
+		},
+		Video: VideoConfig{
+			MaxWidth:   1920,
+			MaxHeight:  1080,
+			MaxBitrate: 8000000,
+			MinCRF:     18,
+			// av1 is left out, software encoding is too slow on most servers
+			Codecs:   []string{"h264", "vp9", "vp8"},
+			Encoders: []string{"libx264", "libopenh264", "libvpx-vp9", "libvpx", "libsvtav1", "libaom-av1"},
+		},
+	}
+}
+
+// LoadWorkerConfig overrides the default config with the file at path, if it exists
+func LoadWorkerConfig(path string) (WorkerConfig, error) {
+	cfg := DefaultWorkerConfig()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
@@ -1,10 +1,12 @@
 package coordinator
 
 import (
+	"slices"
 	"sync"
 )
 
 type (
+	// Binding keeps which users play on which worker, several users can share a worker
 	Binding struct {
 		workers map[string]*Pair
 		users   map[string]*Pair
@@ -12,7 +14,8 @@ type (
 	}
 
 	Pair struct {
-		user   *Connection
+		// users are in join order, the first one started the session
+		users  []*Connection
 		worker *Connection
 	}
 )
@@ -25,42 +28,71 @@ func NewBinding() *Binding {
 	}
 }
 
+// Bind adds the user to the session of the worker, the session is created for the first user
 func (b *Binding) Bind(userConn, workerConn *Connection) {
-	pair := &Pair{
-		user:   userConn,
-		worker: workerConn,
+	b.Lock()
+	defer b.Unlock()
+
+	pair, ok := b.workers[workerConn.id]
+	if !ok {
+		pair = &Pair{worker: workerConn}
+		b.workers[workerConn.id] = pair
 	}
 
-	b.workers[workerConn.id] = pair
+	pair.users = append(pair.users, userConn)
 	b.users[userConn.id] = pair
 }
 
-func (b *Binding) RemoveBinding(id string) *Pair {
-	var (
-		userID   string
-		workerID string
-		pair     *Pair
-	)
+// Join adds the user to the session of an already bound worker, it returns false if there is no such session
+func (b *Binding) Join(userConn *Connection, workerID string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	pair, ok := b.workers[workerID]
+	if !ok {
+		return false
+	}
+
+	pair.users = append(pair.users, userConn)
+	b.users[userConn.id] = pair
+	return true
+}
 
+// RemoveUser removes the user from its session, last is true when nobody is left
+// and the worker is unbound
+func (b *Binding) RemoveUser(id string) (pair *Pair, last bool) {
 	b.Lock()
 	defer b.Unlock()
 
-	if p, ok := b.users[id]; ok {
-		pair = p
-		userID = p.user.id
-		workerID = p.worker.id
-	} else if p, ok := b.workers[id]; ok {
-		pair = p
-		userID = p.user.id
-		workerID = p.worker.id
-	} else {
-		// both worker and user disconnected, only one can call this function
-		return nil
+	pair, ok := b.users[id]
+	if !ok {
+		return nil, false
 	}
+	delete(b.users, id)
 
-	delete(b.workers, workerID)
-	delete(b.users, userID)
+	pair.users = slices.DeleteFunc(pair.users, func(c *Connection) bool { return c.id == id })
+	if len(pair.users) > 0 {
+		return pair, false
+	}
+
+	delete(b.workers, pair.worker.id)
+	return pair, true
+}
+
+// RemoveWorker unbinds the worker and all the users of its session
+func (b *Binding) RemoveWorker(id string) *Pair {
+	b.Lock()
+	defer b.Unlock()
 
+	pair, ok := b.workers[id]
+	if !ok {
+		return nil
+	}
+	delete(b.workers, id)
+
+	for _, user := range pair.users {
+		delete(b.users, user.id)
+	}
 	return pair
 }
 
@@ -73,6 +105,9 @@ func (b *Binding) Unlock() {
 }
 
 func (b *Binding) GetPair(id string) *Pair {
+	b.Lock()
+	defer b.Unlock()
+
 	if p, ok := b.users[id]; ok {
 		return p
 	}
@@ -84,12 +119,42 @@ func (b *Binding) GetPair(id string) *Pair {
 	return nil
 }
 
+// Users returns the users of the session of pair, id empty for all of them
+func (b *Binding) Users(pair *Pair, id string) []*Connection {
+	b.Lock()
+	defer b.Unlock()
+
+	if id == "" {
+		return slices.Clone(pair.users)
+	}
+
+	i := slices.IndexFunc(pair.users, func(c *Connection) bool { return c.id == id })
+	if i < 0 {
+		return nil
+	}
+	return []*Connection{pair.users[i]}
+}
+
+// IsOwner reports whether the user started the session of pair, or is the oldest user left in it
+func (b *Binding) IsOwner(pair *Pair, id string) bool {
+	b.Lock()
+	defer b.Unlock()
+
+	return len(pair.users) > 0 && pair.users[0].id == id
+}
+
 func (b *Binding) IsUserPaired(userId string) bool {
+	b.Lock()
+	defer b.Unlock()
+
 	_, ok := b.users[userId]
 	return ok
 }
 
 func (b *Binding) IsWorkerPaired(workerId string) bool {
+	b.Lock()
+	defer b.Unlock()
+
 	_, ok := b.workers[workerId]
 	return ok
 }
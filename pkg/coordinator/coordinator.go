@@ -27,7 +27,9 @@ type (
 	}
 
 	Connection struct {
-		id   string
+		id string
+		// user is the identity issued to the client, it outlives the connection
+		user string
 		conn *_websocket.Conn
 	}
 )
@@ -35,6 +37,9 @@ type (
 const (
 	User   ConnectionType = "user"
 	Worker ConnectionType = "worker"
+
+	// USER_COOKIE keeps the identity of a client across its connections
+	USER_COOKIE = "cloud_gaming_user"
 )
 
 func New() *Coordinator {
@@ -83,20 +88,34 @@ func (c *Coordinator) handleInitWebSocketUser() http.HandlerFunc {
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
+		user, header := userIdentity(r)
+		conn, err := upgrader.Upgrade(w, r, header)
 		if err != nil {
 			return
 		}
 
 		userConn := &Connection{
 			id:   uuid.New().String(),
+			user: user,
 			conn: _websocket.New(conn),
 		}
 
-		if !c.bindUserAndWorker(userConn) {
-			log.Error("cannot bind worker")
-			conn.Close()
-			return
+		// users join the session of another user with its id, others get a worker of their own
+		sessionID := r.URL.Query().Get("session")
+		if sessionID != "" {
+			if !c.binding.Join(userConn, sessionID) {
+				log.Error("cannot join session", zap.String("session", sessionID))
+				conn.Close()
+				return
+			}
+		} else {
+			workerConn, ok := c.bindUserAndWorker(userConn)
+			if !ok {
+				log.Error("cannot bind worker")
+				conn.Close()
+				return
+			}
+			sessionID = workerConn.id
 		}
 
 		payload, err := json.Marshal(c.getListGames())
@@ -113,20 +132,53 @@ func (c *Coordinator) handleInitWebSocketUser() http.HandlerFunc {
 		})
 
 		log.Debug("Send game list to client", zap.Any("games", c.getListGames()))
+
+		payload, err = json.Marshal(sessionID)
+		if err != nil {
+			log.Error("marshal session id failed", zap.Error(err))
+			return
+		}
+
+		userConn.conn.WriteJSON(message.ResponseMsg{
+			Label:   message.MSG_SESSION,
+			Payload: payload,
+			Error:   nil,
+		})
 		go c.userRequestHandler(userConn)
 	}
 }
 
-func (c *Coordinator) bindUserAndWorker(userConn *Connection) bool {
+// userIdentity returns the identity of the client from its cookie, a new one is issued
+// in the returned header for clients without it
+func userIdentity(r *http.Request) (string, http.Header) {
+	if cookie, err := r.Cookie(USER_COOKIE); err == nil {
+		if _, err := uuid.Parse(cookie.Value); err == nil {
+			return cookie.Value, nil
+		}
+	}
+
+	user := uuid.New().String()
+	header := http.Header{}
+	header.Add("Set-Cookie", (&http.Cookie{
+		Name:     USER_COOKIE,
+		Value:    user,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+	}).String())
+	return user, header
+}
+
+func (c *Coordinator) bindUserAndWorker(userConn *Connection) (*Connection, bool) {
 	for {
 		select {
 		case workerConn := <-c.freeWorkers:
 			if workerConn.conn.GetConnectionStatus() {
 				c.binding.Bind(userConn, workerConn)
-				return true
+				return workerConn, true
 			}
 		default:
-			return false
+			return nil, false
 		}
 	}
 }
@@ -2,6 +2,8 @@ package coordinator
 
 import (
 	"cloud_gaming/pkg/log"
+	"cloud_gaming/pkg/message"
+	"encoding/json"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -17,12 +19,18 @@ func (c *Coordinator) userRequestHandler(connection *Connection) {
 			log.Debug("user web socket closed", zap.Error(err))
 			conn.SetConnectionStatus(false)
 
-			pair := c.binding.RemoveBinding(senderId)
+			pair, last := c.binding.RemoveUser(senderId)
 			if pair == nil {
 				break
 			}
 
-			c.freeWorkers <- pair.worker
+			pair.worker.conn.WriteJSON(message.RequestMsg{
+				Label:  message.MSG_PEER_LEFT,
+				PeerID: senderId,
+			})
+			if last {
+				c.freeWorkers <- pair.worker
+			}
 			conn.Close()
 			break
 		}
@@ -33,6 +41,23 @@ func (c *Coordinator) userRequestHandler(connection *Connection) {
 			break
 		}
 
+		// the worker serves every user of the session, it needs to know who sent the request,
+		// the identity is never taken from the client
+		msg := &message.RequestMsg{}
+		if err := json.Unmarshal(data, msg); err != nil {
+			log.Debug("unmarshal user request failed", zap.Error(err))
+			continue
+		}
+		msg.PeerID = senderId
+		msg.User = connection.user
+		msg.Owner = c.binding.IsOwner(pair, senderId)
+
+		data, err = json.Marshal(msg)
+		if err != nil {
+			log.Error("marshal user request failed", zap.Error(err))
+			continue
+		}
+
 		receiverConn := pair.worker.conn
 		receiverConn.WriteMessage(websocket.TextMessage, data)
 	}
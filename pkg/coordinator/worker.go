@@ -2,6 +2,7 @@ package coordinator
 
 import (
 	"cloud_gaming/pkg/log"
+	"encoding/json"
 
 	"github.com/gorilla/websocket"
 	"go.uber.org/zap"
@@ -17,13 +18,14 @@ func (c *Coordinator) workerRequestHandler(connection *Connection) {
 			log.Debug("worker web socket closed", zap.Error(err))
 			conn.SetConnectionStatus(false)
 
-			pair := c.binding.RemoveBinding(senderId)
+			pair := c.binding.RemoveWorker(senderId)
 			if pair == nil {
 				break
 			}
 
-			workerConn := pair.worker.conn
-			workerConn.Close()
+			for _, user := range pair.users {
+				user.conn.Close()
+			}
 			conn.Close()
 			break
 		}
@@ -34,7 +36,17 @@ func (c *Coordinator) workerRequestHandler(connection *Connection) {
 			break
 		}
 
-		receiverConn := pair.user.conn
-		receiverConn.WriteMessage(websocket.TextMessage, data)
+		// responses with a peer id go to that user only, the others to the whole session
+		var route struct {
+			PeerID string `json:"peer_id"`
+		}
+		if err := json.Unmarshal(data, &route); err != nil {
+			log.Debug("unmarshal worker response failed", zap.Error(err))
+			continue
+		}
+
+		for _, user := range c.binding.Users(pair, route.PeerID) {
+			user.conn.WriteMessage(websocket.TextMessage, data)
+		}
 	}
 }
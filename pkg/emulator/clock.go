@@ -0,0 +1,44 @@
+package emulator
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// mediaClock is the session timeline shared by video and audio,
+	// each frame and the audio produced while it runs are stamped with the time the frame started
+	mediaClock struct {
+		start    atomic.Pointer[time.Time]
+		framePTS atomic.Int64 // in ns
+	}
+)
+
+func (c *mediaClock) Start() {
+	now := time.Now()
+	c.start.Store(&now)
+	c.framePTS.Store(0)
+}
+
+func (c *mediaClock) BeginFrame() {
+	c.framePTS.Store(int64(c.Now()))
+}
+
+// Now returns the current time on the timeline, 0 before the game starts
+func (c *mediaClock) Now() time.Duration {
+	start := c.start.Load()
+	if start == nil {
+		return 0
+	}
+	return time.Since(*start)
+}
+
+// FramePTS returns the presentation time of the frame being run, also used for the audio it produces
+func (e *Emulator) FramePTS() time.Duration {
+	return time.Duration(e.clock.framePTS.Load())
+}
+
+// MediaTime returns the current time on the session timeline, to compare with presentation times
+func (e *Emulator) MediaTime() time.Duration {
+	return e.clock.Now()
+}
@@ -9,12 +9,18 @@ import (
 	"errors"
 	"log"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 	"unsafe"
 )
 
 const (
-	MAX_PLAYERS = 2
+	// MAX_PLAYERS is the maximum number of ports,
+	// the number of active ports is set per game with SetNumPlayers
+	MAX_PLAYERS = 8
+
+	DEFAULT_PLAYERS = 2
 )
 
 type (
@@ -23,14 +29,45 @@ type (
 		state   EmulatorState
 		players [MAX_PLAYERS]Player
 
+		numPlayers atomic.Uint32
+		devices    [MAX_PLAYERS]atomic.Uint32
+
+		// device changes are applied by the emulator thread between two frames
+		pendingDevices chan portDevice
+
+		// delivered to the core's keyboard callback on input poll
+		keyEvents keyboardEvents
+
+		movie movieState
+
 		systemDir  string
 		systemInfo libretro.SystemAVInfo
+		// path of the loaded game, movies starting from power-on reload it
+		gamePath string
+
+		scheduler frameScheduler
+		clock     mediaClock
 
-		// only render next frame if cur_time - prev_time >= 1 / fps
-		lastTime time.Time
+		// previous frame, for the core's frame time callback
+		lastFrameTime time.Time
+
+		// pulls audio from cores using the audio callback, between two frames
+		audioInterval    time.Duration
+		audioStart       time.Time
+		audioStartFrames uint64
+
+		// closed once the emulator thread has exited and the game is unloaded,
+		// stoppedMu guards the field, the channel of a stopped game stays closed
+		stoppedMu sync.Mutex
+		stopped   chan struct{}
 	}
 
 	EmulatorState int
+
+	portDevice struct {
+		port   uint
+		device uint32
+	}
 )
 
 const (
@@ -41,34 +78,24 @@ const (
 )
 
 func New() *Emulator {
-	return &Emulator{
+	e := &Emulator{
 		core:    nil,
 		state:   Ready,
 		players: [MAX_PLAYERS]Player{},
 
+		pendingDevices: make(chan portDevice, MAX_PLAYERS),
+		movie:          movieState{pending: make(chan movieRequest, 1)},
+
 		systemDir: "./libretro/system",
-	}
-}
 
-func KbToRetroPad(btnID uint) (uint, bool) {
-	_map := map[uint]uint{
-		13:  uint(libretro.DeviceIDJoypadStart),  // Enter
-		304: uint(libretro.DeviceIDJoypadSelect), // LShift
-		303: uint(libretro.DeviceIDJoypadSelect), // RShift
-		120: uint(libretro.DeviceIDJoypadA),      // X
-		122: uint(libretro.DeviceIDJoypadB),      // Z
-		119: uint(libretro.DeviceIDJoypadR),      // W
-		113: uint(libretro.DeviceIDJoypadL),      // Q
-		273: uint(libretro.DeviceIDJoypadUp),     // UP
-		274: uint(libretro.DeviceIDJoypadDown),   // DOWN
-		276: uint(libretro.DeviceIDJoypadLeft),   // LEFT
-		275: uint(libretro.DeviceIDJoypadRight),  // RIGHT
+		audioInterval: DEFAULT_AUDIO_CALLBACK_INTERVAL,
 	}
 
-	if retroID, ok := _map[btnID]; ok {
-		return retroID, true
+	e.SetNumPlayers(DEFAULT_PLAYERS)
+	for port := range e.devices {
+		e.devices[port].Store(libretro.DeviceJoypad)
 	}
-	return 0, false
+	return e
 }
 
 func (e *Emulator) LoadCore(
@@ -86,13 +113,17 @@ func (e *Emulator) LoadCore(
 	e.core = core
 	e.core.SetEnvironment(environmentCallback)
 	e.core.SetVideoRefresh(videoRefreshCallback)
-	e.core.SetAudioSample(audioSampleCallback)
-	e.core.SetAudioSampleBatch(audioSampleBatchCallback)
+	e.core.SetAudioSample(func(l, r int16) {
+		e.scheduler.AddAudioFrames(1)
+		audioSampleCallback(l, r)
+	})
+	e.core.SetAudioSampleBatch(func(buf unsafe.Pointer, frames int32) {
+		e.scheduler.AddAudioFrames(frames)
+		audioSampleBatchCallback(buf, frames)
+	})
 	e.core.SetInputState(e.inputStateCallback)
 	e.core.SetInputPoll(e.inputPollCallback)
 
-	// e.core.SetAudioCallback(nil)
-	// e.core.SetFrameTimeCallback(nil)
 	// e.core.SetDiskControlCallback(nil)
 	e.core.MemoryMap = nil
 
@@ -128,6 +159,7 @@ func (e *Emulator) LoadGame(path string) error {
 	}
 
 	e.systemInfo = e.core.GetSystemAVInfo()
+	e.gamePath = path
 	return nil
 }
 
@@ -137,41 +169,149 @@ func (e *Emulator) UnloadGame() {
 
 // Run runs the game for one video frame.
 func (e *Emulator) run() {
-	curTime := time.Now()
-	delta := time.Second / time.Duration(e.systemInfo.Timing.FPS)
-	if time.Since((e.lastTime)) >= delta {
-		e.core.Run()
-		e.lastTime = curTime
+	e.applyPendingDevices()
+	e.beginMovieFrame()
+	e.callFrameTime()
+	e.clock.BeginFrame()
+	e.core.Run()
+	e.endMovieFrame()
+}
+
+// SetNumPlayers sets the number of active ports, clamped to [1, MAX_PLAYERS]
+func (e *Emulator) SetNumPlayers(n uint) {
+	e.numPlayers.Store(uint32(min(max(n, 1), MAX_PLAYERS)))
+}
+
+func (e *Emulator) GetNumPlayers() uint {
+	return uint(e.numPlayers.Load())
+}
+
+func (e *Emulator) isValidPort(port uint) bool {
+	return port < e.GetNumPlayers()
+}
+
+// SetControllerPortDevice selects the device plugged in a port, e.g: a multitap.
+// The change is applied before the next frame.
+func (e *Emulator) SetControllerPortDevice(port uint, device uint32) error {
+	if !e.isValidPort(port) {
+		return errors.New("invalid port")
+	}
+
+	select {
+	case e.pendingDevices <- portDevice{port: port, device: device}:
+		return nil
+	default:
+		return errors.New("too many pending device changes")
 	}
 }
 
-func (e *Emulator) StartGame() {
-	go e.startGame()
+func (e *Emulator) GetControllerPortDevice(port uint) uint32 {
+	if port >= MAX_PLAYERS {
+		return libretro.DeviceNone
+	}
+	return e.devices[port].Load()
+}
+
+func (e *Emulator) resetDevices() {
+	for {
+		select {
+		case <-e.pendingDevices:
+		default:
+			for port := range e.devices {
+				e.devices[port].Store(libretro.DeviceJoypad)
+			}
+			return
+		}
+	}
+}
+
+func (e *Emulator) applyPendingDevices() {
+	for {
+		select {
+		case pd := <-e.pendingDevices:
+			e.core.SetControllerPortDevice(pd.port, pd.device)
+			e.devices[pd.port].Store(pd.device)
+		default:
+			return
+		}
+	}
 }
 
-func (e *Emulator) startGame() {
+func (e *Emulator) StartGame() {
+	stopped := make(chan struct{})
+	e.stoppedMu.Lock()
+	e.stopped = stopped
+	e.stoppedMu.Unlock()
+
 	e.SetState(Running)
+	go e.startGame(stopped)
+}
+
+func (e *Emulator) startGame(stopped chan struct{}) {
+	defer close(stopped)
 
+	e.lastFrameTime = time.Time{}
+	e.scheduler.Start(e.systemInfo.Timing.FPS, e.systemInfo.Timing.SampleRate)
+	e.clock.Start()
+	e.startAudioCallback()
 	for e.IsRunning() {
+		e.scheduler.Wait()
 		e.run()
+		e.runAudioCallback()
 	}
+	e.stopAudioCallback()
+	e.scheduler.Stop()
 
 	e.stopGame()
 }
 
+// StopGame returns once the emulator thread has exited, the core no longer writes
+// to the buffers it was given and they can be freed
 func (e *Emulator) StopGame() {
 	if e.IsRunning() {
 		e.SetState(Deinitializing)
 	}
+
+	e.stoppedMu.Lock()
+	stopped := e.stopped
+	e.stoppedMu.Unlock()
+
+	if stopped != nil {
+		<-stopped
+	}
 }
 
 func (e *Emulator) stopGame() {
 	e.SetState(Deinitializing)
 	e.UnloadGame()
 	e.DeInit()
+	e.resetDevices()
+	e.resetMovie()
 	e.SetState(Ready)
 }
 
+// SetSystemAVInfo is called on EnvironmentSetSystemAVInfo, from the emulator thread
+func (e *Emulator) SetSystemAVInfo(systemAVInfo libretro.SystemAVInfo) {
+	e.systemInfo = systemAVInfo
+	if e.IsRunning() {
+		e.scheduler.SetTiming(systemAVInfo.Timing.FPS, systemAVInfo.Timing.SampleRate)
+	}
+}
+
+// SetGeometry is called on EnvironmentSetGeometry, timings are unchanged
+func (e *Emulator) SetGeometry(geometry libretro.GameGeometry) {
+	e.systemInfo.Geometry = geometry
+}
+
+// SetPacing selects whether frames are paced by the video frame rate or by the produced audio
+func (e *Emulator) SetPacing(pacing Pacing) {
+	e.scheduler.SetPacing(pacing)
+}
+
+func (e *Emulator) GetFrameStats() FrameStats {
+	return e.scheduler.Stats()
+}
+
 // GetSystemAVInfo returns information about
 // system audio/video timings and geometry.
 // Can be called only after retro_load_game() has successfully completed.
@@ -195,6 +335,10 @@ func (e *Emulator) BindLogCallback(data unsafe.Pointer, logFunc func(uint32, str
 	e.core.BindLogCallback(data, logFunc)
 }
 
+func (e *Emulator) BindRumbleInterface(data unsafe.Pointer, rumbleFunc libretro.RumbleFunc) {
+	e.core.BindRumbleInterface(data, rumbleFunc)
+}
+
 func (e *Emulator) GetSystemDirectory() string {
 	return e.systemDir
 }
@@ -215,39 +359,118 @@ func (e *Emulator) GetState() EmulatorState {
 	return e.state
 }
 
-func (e *Emulator) inputPollCallback() {}
+func (e *Emulator) inputPollCallback() {
+	for i := range e.players {
+		e.players[i].Poll()
+	}
+
+	// keyboard callback is invoked from the emulator thread, as the core expects
+	events := e.movieKeyEvents(e.keyEvents.Drain())
+	if e.core.KeyboardCallback == nil {
+		return
+	}
+
+	for _, ev := range events {
+		e.core.KeyboardCallback.Callback(ev.Down, ev.Keycode, ev.Character, ev.Modifiers)
+	}
+}
+
+func (e *Emulator) SetKeyboardCallback(data unsafe.Pointer) {
+	e.core.SetKeyboardCallback(data)
+}
 
 func (e *Emulator) inputStateCallback(port uint, device uint32, index uint, id uint) int16 {
-	if port >= MAX_PLAYERS {
+	if !e.isValidPort(port) {
 		return 0
 	}
 
+	device &= libretro.DeviceMask
+	if value, ok := e.movieInputState(port, device, index, id); ok {
+		return value
+	}
+
 	return e.players[port].GetKeyState(device, index, id)
 }
 
-func (e *Emulator) SetKeyboardState(port uint, id uint, pressed bool) {
-	if port >= MAX_PLAYERS {
+// SetKeyboardState updates the retro_key id of a port,
+// character is the produced character (0 if none) which is only forwarded to the core's keyboard callback
+func (e *Emulator) SetKeyboardState(port uint, id uint, character uint32, pressed bool) {
+	if !e.isValidPort(port) {
 		return
 	}
 
-	e.players[port].SetKeyboardState(id, pressed)
-	if retroID, found := KbToRetroPad(id); found {
+	modifiers := e.players[port].SetKeyboardState(id, pressed)
+	if e.core != nil && e.core.KeyboardCallback != nil {
+		e.keyEvents.Push(KeyboardEvent{
+			Down:      pressed,
+			Keycode:   id,
+			Character: character,
+			Modifiers: modifiers,
+		})
+	}
+
+	if retroID, found := e.players[port].GetInputMapping().KeyboardToRetroPad(id); found {
 		e.players[port].SetRetroPadState(retroID, pressed)
 	}
 }
 
-func (e *Emulator) SetMouseState(port uint, id uint) {
-	if port >= MAX_PLAYERS {
+// SetInputMapping sets the active mapping of a port, nil resets it to the default one
+func (e *Emulator) SetInputMapping(port uint, mapping *InputMapping) {
+	if !e.isValidPort(port) {
 		return
 	}
 
-	e.players[port].SetMouseState(id)
+	e.players[port].SetInputMapping(mapping)
 }
 
-func (e *Emulator) SetMousePos(port uint, x, y int) {
-	if port >= MAX_PLAYERS {
+// SetGamepadButtons sets the gamepad buttons of a port, bit i is the state of gamepad button i
+func (e *Emulator) SetGamepadButtons(port uint, mask uint16) {
+	if !e.isValidPort(port) {
+		return
+	}
+
+	mask = e.players[port].GetInputMapping().GamepadToRetroPad(mask)
+	e.players[port].SetRetroPadButtons(mask)
+}
+
+func (e *Emulator) SetAnalogState(port uint, index uint, id uint, value int32) {
+	if !e.isValidPort(port) {
+		return
+	}
+
+	e.players[port].SetAnalogState(index, id, value)
+}
+
+func (e *Emulator) SetMouseButtons(port uint, buttons uint32) {
+	if !e.isValidPort(port) {
+		return
+	}
+
+	e.players[port].SetMouseButtons(buttons)
+}
+
+func (e *Emulator) MoveMouse(port uint, dx, dy int32) {
+	if !e.isValidPort(port) {
+		return
+	}
+
+	e.players[port].MoveMouse(dx, dy)
+}
+
+func (e *Emulator) ScrollMouse(port uint, wheel, hwheel int32) {
+	if !e.isValidPort(port) {
+		return
+	}
+
+	e.players[port].ScrollMouse(wheel, hwheel)
+}
+
+// SetPointerPos sets the absolute position used by lightgun and pointer devices,
+// x and y are in [-0x7fff, 0x7fff] where (-0x7fff, -0x7fff) is the top left corner of the game screen
+func (e *Emulator) SetPointerPos(port uint, x, y int16, offscreen bool) {
+	if !e.isValidPort(port) {
 		return
 	}
 
-	e.players[port].SetMousePos(x, y)
+	e.players[port].SetPointerPos(x, y, offscreen)
 }
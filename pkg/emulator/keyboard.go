@@ -0,0 +1,255 @@
+package emulator
+
+import (
+	"cloud_gaming/pkg/libretro"
+	"sync"
+)
+
+type (
+	KeyBoard struct {
+		mu        sync.Mutex
+		states    [libretro.NO_KB_KEYS]bool
+		modifiers uint16
+	}
+
+	// KeyboardEvent is delivered to the core's keyboard callback
+	KeyboardEvent struct {
+		Down      bool
+		Keycode   uint
+		Character uint32
+		Modifiers uint16
+	}
+
+	// keyboardEvents queues events from the data channel until the core polls its input
+	keyboardEvents struct {
+		mu     sync.Mutex
+		events []KeyboardEvent
+	}
+)
+
+const (
+	MAX_KEYBOARD_EVENTS = 256
+)
+
+// retro_key of the modifier keys
+const (
+	retroKNumLock   uint = 300
+	retroKCapsLock  uint = 301
+	retroKScrollock uint = 302
+	retroKRShift    uint = 303
+	retroKLShift    uint = 304
+	retroKRCtrl     uint = 305
+	retroKLCtrl     uint = 306
+	retroKRAlt      uint = 307
+	retroKLAlt      uint = 308
+	retroKRMeta     uint = 309
+	retroKLMeta     uint = 310
+)
+
+// browserCodeToRetroKey maps browser's KeyboardEvent.code to libretro's retro_key
+var browserCodeToRetroKey = map[string]uint{
+	"Backspace":    8,
+	"Tab":          9,
+	"Enter":        13,
+	"Pause":        19,
+	"Escape":       27,
+	"Space":        32,
+	"Quote":        39,
+	"Comma":        44,
+	"Minus":        45,
+	"Period":       46,
+	"Slash":        47,
+	"Digit0":       48,
+	"Digit1":       49,
+	"Digit2":       50,
+	"Digit3":       51,
+	"Digit4":       52,
+	"Digit5":       53,
+	"Digit6":       54,
+	"Digit7":       55,
+	"Digit8":       56,
+	"Digit9":       57,
+	"Semicolon":    59,
+	"Equal":        61,
+	"BracketLeft":  91,
+	"Backslash":    92,
+	"BracketRight": 93,
+	"Backquote":    96,
+	"KeyA":         97,
+	"KeyB":         98,
+	"KeyC":         99,
+	"KeyD":         100,
+	"KeyE":         101,
+	"KeyF":         102,
+	"KeyG":         103,
+	"KeyH":         104,
+	"KeyI":         105,
+	"KeyJ":         106,
+	"KeyK":         107,
+	"KeyL":         108,
+	"KeyM":         109,
+	"KeyN":         110,
+	"KeyO":         111,
+	"KeyP":         112,
+	"KeyQ":         113,
+	"KeyR":         114,
+	"KeyS":         115,
+	"KeyT":         116,
+	"KeyU":         117,
+	"KeyV":         118,
+	"KeyW":         119,
+	"KeyX":         120,
+	"KeyY":         121,
+	"KeyZ":         122,
+	"Delete":       127,
+
+	"Numpad0":        256,
+	"Numpad1":        257,
+	"Numpad2":        258,
+	"Numpad3":        259,
+	"Numpad4":        260,
+	"Numpad5":        261,
+	"Numpad6":        262,
+	"Numpad7":        263,
+	"Numpad8":        264,
+	"Numpad9":        265,
+	"NumpadDecimal":  266,
+	"NumpadDivide":   267,
+	"NumpadMultiply": 268,
+	"NumpadSubtract": 269,
+	"NumpadAdd":      270,
+	"NumpadEnter":    271,
+	"NumpadEqual":    272,
+
+	"ArrowUp":    273,
+	"ArrowDown":  274,
+	"ArrowRight": 275,
+	"ArrowLeft":  276,
+	"Insert":     277,
+	"Home":       278,
+	"End":        279,
+	"PageUp":     280,
+	"PageDown":   281,
+
+	"F1":  282,
+	"F2":  283,
+	"F3":  284,
+	"F4":  285,
+	"F5":  286,
+	"F6":  287,
+	"F7":  288,
+	"F8":  289,
+	"F9":  290,
+	"F10": 291,
+	"F11": 292,
+	"F12": 293,
+	"F13": 294,
+	"F14": 295,
+	"F15": 296,
+
+	"NumLock":      retroKNumLock,
+	"CapsLock":     retroKCapsLock,
+	"ScrollLock":   retroKScrollock,
+	"ShiftRight":   retroKRShift,
+	"ShiftLeft":    retroKLShift,
+	"ControlRight": retroKRCtrl,
+	"ControlLeft":  retroKLCtrl,
+	"AltRight":     retroKRAlt,
+	"AltLeft":      retroKLAlt,
+	"MetaRight":    retroKRMeta,
+	"MetaLeft":     retroKLMeta,
+
+	"PrintScreen":   316,
+	"ContextMenu":   319,
+	"Power":         320,
+	"IntlBackslash": 323,
+}
+
+// BrowserCodeToRetroKey returns RETROK_UNKNOWN (0) for unknown codes
+func BrowserCodeToRetroKey(code string) uint {
+	return browserCodeToRetroKey[code]
+}
+
+// BrowserKeyToCharacter converts browser's KeyboardEvent.key to the character sent to the core,
+// named keys without a printable character return 0
+func BrowserKeyToCharacter(key string) uint32 {
+	switch key {
+	case "Enter":
+		return '\r'
+	case "Tab":
+		return '\t'
+	case "Backspace":
+		return '\b'
+	case "Escape":
+		return 0x1b
+	}
+
+	runes := []rune(key)
+	if len(runes) != 1 {
+		return 0
+	}
+	return uint32(runes[0])
+}
+
+func (kb *KeyBoard) GetState(id uint) bool {
+	if id >= libretro.NO_KB_KEYS {
+		return false
+	}
+	return kb.states[id]
+}
+
+// SetState updates the key and returns the modifiers after the update
+func (kb *KeyBoard) SetState(id uint, pressed bool) uint16 {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+
+	if id >= libretro.NO_KB_KEYS {
+		return kb.modifiers
+	}
+
+	// lock keys toggle on key down
+	if pressed && !kb.states[id] {
+		switch id {
+		case retroKNumLock:
+			kb.modifiers ^= libretro.KeyModNumLock
+		case retroKCapsLock:
+			kb.modifiers ^= libretro.KeyModCapsLock
+		case retroKScrollock:
+			kb.modifiers ^= libretro.KeyModScrollLock
+		}
+	}
+	kb.states[id] = pressed
+
+	kb.modifiers = kb.setModifier(kb.modifiers, libretro.KeyModShift, retroKLShift, retroKRShift)
+	kb.modifiers = kb.setModifier(kb.modifiers, libretro.KeyModCtrl, retroKLCtrl, retroKRCtrl)
+	kb.modifiers = kb.setModifier(kb.modifiers, libretro.KeyModAlt, retroKLAlt, retroKRAlt)
+	kb.modifiers = kb.setModifier(kb.modifiers, libretro.KeyModMeta, retroKLMeta, retroKRMeta)
+	return kb.modifiers
+}
+
+func (kb *KeyBoard) setModifier(modifiers uint16, mod uint16, left, right uint) uint16 {
+	if kb.states[left] || kb.states[right] {
+		return modifiers | mod
+	}
+	return modifiers &^ mod
+}
+
+// Push drops the oldest event once MAX_KEYBOARD_EVENTS events are pending
+func (q *keyboardEvents) Push(event KeyboardEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.events) >= MAX_KEYBOARD_EVENTS {
+		q.events = q.events[1:]
+	}
+	q.events = append(q.events, event)
+}
+
+func (q *keyboardEvents) Drain() []KeyboardEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	events := q.events
+	q.events = nil
+	return events
+}
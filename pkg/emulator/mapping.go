@@ -0,0 +1,91 @@
+package emulator
+
+import (
+	"cloud_gaming/pkg/libretro"
+	"maps"
+)
+
+type (
+	// InputMapping maps client's inputs to retropad buttons
+	InputMapping struct {
+		Keyboard map[uint]uint // retro_key -> retropad id
+		Gamepad  map[uint]uint // gamepad button (browser standard layout) -> retropad id
+	}
+)
+
+func DefaultInputMapping() *InputMapping {
+	return &InputMapping{
+		Keyboard: map[uint]uint{
+			13:  uint(libretro.DeviceIDJoypadStart),  // Enter
+			304: uint(libretro.DeviceIDJoypadSelect), // LShift
+			303: uint(libretro.DeviceIDJoypadSelect), // RShift
+			120: uint(libretro.DeviceIDJoypadA),      // X
+			122: uint(libretro.DeviceIDJoypadB),      // Z
+			119: uint(libretro.DeviceIDJoypadR),      // W
+			113: uint(libretro.DeviceIDJoypadL),      // Q
+			273: uint(libretro.DeviceIDJoypadUp),     // UP
+			274: uint(libretro.DeviceIDJoypadDown),   // DOWN
+			276: uint(libretro.DeviceIDJoypadLeft),   // LEFT
+			275: uint(libretro.DeviceIDJoypadRight),  // RIGHT
+		},
+		Gamepad: map[uint]uint{
+			0:  uint(libretro.DeviceIDJoypadB),      // bottom
+			1:  uint(libretro.DeviceIDJoypadA),      // right
+			2:  uint(libretro.DeviceIDJoypadY),      // left
+			3:  uint(libretro.DeviceIDJoypadX),      // top
+			4:  uint(libretro.DeviceIDJoypadL),      // left bumper
+			5:  uint(libretro.DeviceIDJoypadR),      // right bumper
+			6:  uint(libretro.DeviceIDJoypadL2),     // left trigger
+			7:  uint(libretro.DeviceIDJoypadR2),     // right trigger
+			8:  uint(libretro.DeviceIDJoypadSelect), // back
+			9:  uint(libretro.DeviceIDJoypadStart),  // start
+			10: uint(libretro.DeviceIDJoypadL3),     // left stick
+			11: uint(libretro.DeviceIDJoypadR3),     // right stick
+			12: uint(libretro.DeviceIDJoypadUp),     // dpad up
+			13: uint(libretro.DeviceIDJoypadDown),   // dpad down
+			14: uint(libretro.DeviceIDJoypadLeft),   // dpad left
+			15: uint(libretro.DeviceIDJoypadRight),  // dpad right
+		},
+	}
+}
+
+// Merge returns a new mapping where entries of o override entries of m
+func (m *InputMapping) Merge(o *InputMapping) *InputMapping {
+	res := &InputMapping{
+		Keyboard: maps.Clone(m.Keyboard),
+		Gamepad:  maps.Clone(m.Gamepad),
+	}
+
+	if res.Keyboard == nil {
+		res.Keyboard = make(map[uint]uint)
+	}
+	if res.Gamepad == nil {
+		res.Gamepad = make(map[uint]uint)
+	}
+
+	maps.Copy(res.Keyboard, o.Keyboard)
+	maps.Copy(res.Gamepad, o.Gamepad)
+	return res
+}
+
+func (m *InputMapping) KeyboardToRetroPad(key uint) (uint, bool) {
+	retroID, ok := m.Keyboard[key]
+	return retroID, ok
+}
+
+// GamepadToRetroPad converts a gamepad buttons mask into a retropad buttons mask,
+// bit i of the result is the state of retropad button id i
+func (m *InputMapping) GamepadToRetroPad(buttons uint16) uint16 {
+	var mask uint16
+	for btn := uint(0); btn < 16; btn++ {
+		if buttons&(1<<btn) == 0 {
+			continue
+		}
+
+		if retroID, ok := m.Gamepad[btn]; ok && retroID < 16 {
+			mask |= 1 << retroID
+		}
+	}
+
+	return mask
+}
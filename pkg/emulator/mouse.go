@@ -0,0 +1,164 @@
+package emulator
+
+import (
+	"cloud_gaming/pkg/libretro"
+	"sync/atomic"
+)
+
+type (
+	// Mouse backs the mouse, lightgun and pointer devices of a player
+	Mouse struct {
+		// accumulated since the last poll
+		dx, dy        atomic.Int32
+		wheel, hwheel atomic.Int32
+
+		buttons atomic.Uint32
+
+		// absolute position in [-0x7fff, 0x7fff]
+		x, y      atomic.Int32
+		offscreen atomic.Bool
+
+		// snapshot taken on input poll, only accessed from the emulator thread
+		polled mousePoll
+	}
+
+	mousePoll struct {
+		dx, dy        int32
+		wheel, hwheel int32
+	}
+)
+
+// Mouse buttons, same bits as browser's MouseEvent.buttons
+const (
+	LeftMouse uint32 = 1 << iota
+	RightMouse
+	MiddleMouse
+	BackMouse
+	ForwardMouse
+)
+
+func (m *Mouse) Poll() {
+	m.polled = mousePoll{
+		dx:     m.dx.Swap(0),
+		dy:     m.dy.Swap(0),
+		wheel:  m.wheel.Swap(0),
+		hwheel: m.hwheel.Swap(0),
+	}
+}
+
+func (m *Mouse) SetButtons(buttons uint32) {
+	m.buttons.Store(buttons)
+}
+
+func (m *Mouse) Move(dx, dy int32) {
+	m.dx.Add(dx)
+	m.dy.Add(dy)
+}
+
+// Scroll accumulates wheel movements, negative value means up (or left for hwheel)
+func (m *Mouse) Scroll(wheel, hwheel int32) {
+	m.wheel.Add(wheel)
+	m.hwheel.Add(hwheel)
+}
+
+func (m *Mouse) SetPointerPos(x, y int16, offscreen bool) {
+	m.x.Store(int32(x))
+	m.y.Store(int32(y))
+	m.offscreen.Store(offscreen)
+}
+
+func (m *Mouse) isPressed(button uint32) bool {
+	return m.buttons.Load()&button != 0
+}
+
+func (m *Mouse) GetMouseState(id uint) int16 {
+	switch uint32(id) {
+	case libretro.DeviceIDMouseX:
+		return clampInt16(m.polled.dx)
+	case libretro.DeviceIDMouseY:
+		return clampInt16(m.polled.dy)
+	case libretro.DeviceIDMouseLeft:
+		return boolToInt16(m.isPressed(LeftMouse))
+	case libretro.DeviceIDMouseRight:
+		return boolToInt16(m.isPressed(RightMouse))
+	case libretro.DeviceIDMouseMiddle:
+		return boolToInt16(m.isPressed(MiddleMouse))
+	case libretro.DeviceIDMouseButton4:
+		return boolToInt16(m.isPressed(BackMouse))
+	case libretro.DeviceIDMouseButton5:
+		return boolToInt16(m.isPressed(ForwardMouse))
+	case libretro.DeviceIDMouseWheelUp:
+		return boolToInt16(m.polled.wheel < 0)
+	case libretro.DeviceIDMouseWheelDown:
+		return boolToInt16(m.polled.wheel > 0)
+	case libretro.DeviceIDMouseHorizWheelUp:
+		return boolToInt16(m.polled.hwheel < 0)
+	case libretro.DeviceIDMouseHorizWheelDown:
+		return boolToInt16(m.polled.hwheel > 0)
+	default:
+		return 0
+	}
+}
+
+func (m *Mouse) GetLightgunState(id uint) int16 {
+	offscreen := m.offscreen.Load()
+
+	switch uint32(id) {
+	case libretro.DeviceIDLightgunScreenX:
+		return int16(m.x.Load())
+	case libretro.DeviceIDLightgunScreenY:
+		return int16(m.y.Load())
+	case libretro.DeviceIDLightgunIsOffscreen:
+		return boolToInt16(offscreen)
+	case libretro.DeviceIDLightgunTrigger:
+		return boolToInt16(m.isPressed(LeftMouse) && !offscreen)
+	case libretro.DeviceIDLightgunReload:
+		// shooting outside of the screen reloads
+		return boolToInt16(m.isPressed(RightMouse) || (m.isPressed(LeftMouse) && offscreen))
+	case libretro.DeviceIDLightgunAuxA:
+		return boolToInt16(m.isPressed(MiddleMouse))
+	case libretro.DeviceIDLightgunAuxB:
+		return boolToInt16(m.isPressed(BackMouse))
+	case libretro.DeviceIDLightgunStart:
+		return boolToInt16(m.isPressed(ForwardMouse))
+	case libretro.DeviceIDLightgunX:
+		return clampInt16(m.polled.dx)
+	case libretro.DeviceIDLightgunY:
+		return clampInt16(m.polled.dy)
+	default:
+		return 0
+	}
+}
+
+// GetPointerState only supports a single touch, index > 0 is never pressed
+func (m *Mouse) GetPointerState(index uint, id uint) int16 {
+	if index > 0 {
+		return 0
+	}
+
+	pressed := m.isPressed(LeftMouse) && !m.offscreen.Load()
+
+	switch uint32(id) {
+	case libretro.DeviceIDPointerX:
+		return int16(m.x.Load())
+	case libretro.DeviceIDPointerY:
+		return int16(m.y.Load())
+	case libretro.DeviceIDPointerPressed:
+		return boolToInt16(pressed)
+	case libretro.DeviceIDPointerCount:
+		return boolToInt16(pressed)
+	default:
+		return 0
+	}
+}
+
+func boolToInt16(b bool) int16 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func clampInt16(v int32) int16 {
+	return int16(min(max(v, AnalogMin), AnalogMax))
+}
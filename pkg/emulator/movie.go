@@ -0,0 +1,568 @@
+package emulator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+type (
+	MovieMode int
+
+	// Movie is a recording of every input state change, played back frame by frame
+	Movie struct {
+		NumPlayers uint
+		State      []byte // save state the movie starts from, nil for power-on
+		Frames     uint32
+		Events     []MovieEvent    // ordered by frame
+		KeyEvents  []MovieKeyEvent // ordered by frame
+	}
+
+	// MovieEvent is the new value of an input, as returned by inputStateCallback from Frame on
+	MovieEvent struct {
+		Frame uint32
+		Input MovieInput
+		Value int16
+	}
+
+	// MovieKeyEvent is an event given to the core's keyboard callback on the input poll of Frame
+	MovieKeyEvent struct {
+		Frame uint32
+		Event KeyboardEvent
+	}
+
+	MovieInput struct {
+		Port   uint8
+		Device uint8
+		Index  uint8
+		ID     uint16
+	}
+
+	MovieStatus struct {
+		Mode   MovieMode
+		Frame  uint32
+		Frames uint32 // length of the movie, grows while recording
+	}
+
+	// movieState is only accessed from the emulator thread, except through its mutex
+	movieState struct {
+		mu    sync.Mutex
+		mode  MovieMode
+		movie *Movie
+		loop  bool
+		frame uint32
+		next  int // index of the next event to replay
+
+		nextKey int // index of the next keyboard event to replay
+		// keyboard events replayed in the current frame, delivered on input poll
+		keyEvents []KeyboardEvent
+
+		// last recorded or replayed value of each input
+		values map[MovieInput]int16
+		// values read by the core in the current frame while recording,
+		// so that the core sees the same value as the one recorded
+		frameValues map[MovieInput]int16
+		// numPlayers of the session before a playback, restored once it ends
+		numPlayers uint
+
+		pending chan movieRequest
+	}
+
+	movieRequest struct {
+		mode      MovieMode
+		movie     *Movie // playback only
+		fromState bool   // recording only
+		loop      bool   // playback only
+		done      chan error
+	}
+)
+
+const (
+	MovieNone MovieMode = iota
+	MovieRecording
+	MoviePlayback
+)
+
+const (
+	MOVIE_MAGIC   = "CGMV"
+	MOVIE_VERSION = 2
+
+	// how long a movie request waits for the emulator thread
+	MOVIE_REQUEST_TIMEOUT = time.Second
+)
+
+const (
+	movieFlagState uint8 = 1 << iota
+)
+
+// StartRecording records inputs read by the core and the events of its keyboard callback.
+// Recording begins on the next frame, which starts from a save state or, when fromState is false, from power-on
+func (e *Emulator) StartRecording(fromState bool) error {
+	return e.requestMovie(movieRequest{mode: MovieRecording, fromState: fromState})
+}
+
+// StartPlayback replays movie from the next frame instead of the clients' inputs,
+// the movie restarts once finished if loop is set
+func (e *Emulator) StartPlayback(movie *Movie, loop bool) error {
+	if movie == nil {
+		return errors.New("nil movie")
+	}
+	return e.requestMovie(movieRequest{mode: MoviePlayback, movie: movie, loop: loop})
+}
+
+// StopRecording ends the recording and returns the recorded movie
+func (e *Emulator) StopRecording() (*Movie, error) {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode != MovieRecording {
+		return nil, errors.New("not recording")
+	}
+
+	movie := m.movie
+	movie.Frames = m.frame
+	e.endMovie()
+	return movie, nil
+}
+
+func (e *Emulator) StopPlayback() {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode == MoviePlayback {
+		e.endMovie()
+	}
+}
+
+func (e *Emulator) GetMovieStatus() MovieStatus {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := MovieStatus{Mode: m.mode, Frame: m.frame}
+	switch m.mode {
+	case MovieRecording:
+		status.Frames = m.frame
+	case MoviePlayback:
+		status.Frames = m.movie.Frames
+	}
+	return status
+}
+
+func (e *Emulator) requestMovie(req movieRequest) error {
+	if !e.IsRunning() {
+		return errors.New("emulator is not running")
+	}
+
+	req.done = make(chan error, 1)
+	select {
+	case e.movie.pending <- req:
+	default:
+		return errors.New("movie request already pending")
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-time.After(MOVIE_REQUEST_TIMEOUT):
+		return errors.New("movie request timed out")
+	}
+}
+
+// beginMovieFrame runs on the emulator thread before each frame
+func (e *Emulator) beginMovieFrame() {
+	select {
+	case req := <-e.movie.pending:
+		req.done <- e.startMovie(req)
+	default:
+	}
+
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.mode {
+	case MovieRecording:
+		clear(m.frameValues)
+	case MoviePlayback:
+		if m.frame >= m.movie.Frames {
+			if !m.loop {
+				e.endMovie()
+				return
+			}
+
+			if err := e.restoreMovieStart(m.movie); err != nil {
+				e.endMovie()
+				return
+			}
+			m.frame = 0
+			m.next = 0
+			m.nextKey = 0
+			clear(m.values)
+		}
+
+		events := m.movie.Events
+		for m.next < len(events) && events[m.next].Frame <= m.frame {
+			m.values[events[m.next].Input] = events[m.next].Value
+			m.next++
+		}
+
+		keyEvents := m.movie.KeyEvents
+		for m.nextKey < len(keyEvents) && keyEvents[m.nextKey].Frame <= m.frame {
+			m.keyEvents = append(m.keyEvents, keyEvents[m.nextKey].Event)
+			m.nextKey++
+		}
+	}
+}
+
+// endMovieFrame runs on the emulator thread after each frame
+func (e *Emulator) endMovieFrame() {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode != MovieNone {
+		m.frame++
+	}
+}
+
+func (e *Emulator) startMovie(req movieRequest) error {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode != MovieNone {
+		return errors.New("movie already in progress")
+	}
+
+	var movie *Movie
+	switch req.mode {
+	case MovieRecording:
+		movie = &Movie{NumPlayers: e.GetNumPlayers()}
+		if req.fromState {
+			state, err := e.core.Serialize(e.core.SerializeSize())
+			if err != nil {
+				return err
+			}
+			movie.State = state
+		}
+	case MoviePlayback:
+		movie = req.movie
+	}
+
+	if err := e.restoreMovieStart(movie); err != nil {
+		return err
+	}
+
+	if req.mode == MoviePlayback {
+		m.numPlayers = e.GetNumPlayers()
+		e.SetNumPlayers(movie.NumPlayers)
+	}
+
+	m.mode = req.mode
+	m.movie = movie
+	m.loop = req.loop
+	m.frame = 0
+	m.next = 0
+	m.nextKey = 0
+	m.keyEvents = nil
+	m.values = make(map[MovieInput]int16)
+	m.frameValues = make(map[MovieInput]int16)
+	return nil
+}
+
+// restoreMovieStart loads the save state of the movie, or reloads the game if the movie starts from power-on.
+// A reset keeps parts of the core state (e.g: RAM of some systems), only a reload replays the same way
+func (e *Emulator) restoreMovieStart(movie *Movie) error {
+	if movie.State == nil {
+		return e.reloadGame()
+	}
+	return e.core.Unserialize(movie.State, e.core.SerializeSize())
+}
+
+// reloadGame unloads and loads the game again, the devices plugged in the ports are kept
+func (e *Emulator) reloadGame() error {
+	e.core.UnloadGame()
+	if err := e.LoadGame(e.gamePath); err != nil {
+		return err
+	}
+
+	for port := uint(0); port < e.GetNumPlayers(); port++ {
+		e.core.SetControllerPortDevice(port, e.devices[port].Load())
+	}
+	return nil
+}
+
+// movieInputState returns the value the core reads, ok is false when no movie is in progress
+func (e *Emulator) movieInputState(port uint, device uint32, index uint, id uint) (int16, bool) {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	input := MovieInput{Port: uint8(port), Device: uint8(device), Index: uint8(index), ID: uint16(id)}
+
+	switch m.mode {
+	case MovieRecording:
+		if value, ok := m.frameValues[input]; ok {
+			return value, true
+		}
+
+		value := e.players[port].GetKeyState(device, index, id)
+		m.frameValues[input] = value
+		if last, ok := m.values[input]; (!ok && value != 0) || (ok && last != value) {
+			m.values[input] = value
+			m.movie.Events = append(m.movie.Events, MovieEvent{Frame: m.frame, Input: input, Value: value})
+		}
+		return value, true
+	case MoviePlayback:
+		return m.values[input], true
+	default:
+		return 0, false
+	}
+}
+
+// movieKeyEvents returns the events for the core's keyboard callback, the clients' events are recorded
+// while recording and replaced by the ones of the movie on playback
+func (e *Emulator) movieKeyEvents(events []KeyboardEvent) []KeyboardEvent {
+	m := &e.movie
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.mode {
+	case MovieRecording:
+		for _, ev := range events {
+			m.movie.KeyEvents = append(m.movie.KeyEvents, MovieKeyEvent{Frame: m.frame, Event: ev})
+		}
+		return events
+	case MoviePlayback:
+		events = m.keyEvents
+		m.keyEvents = nil
+		return events
+	default:
+		return events
+	}
+}
+
+// resetMovie stops any movie when the game stops
+func (e *Emulator) resetMovie() {
+	for {
+		select {
+		case req := <-e.movie.pending:
+			req.done <- errors.New("game stopped")
+		default:
+			e.movie.mu.Lock()
+			e.endMovie()
+			e.movie.mu.Unlock()
+			return
+		}
+	}
+}
+
+// endMovie stops the movie in progress, the number of players a playback changed is restored.
+// The movie mutex must be held
+func (e *Emulator) endMovie() {
+	m := &e.movie
+	if m.mode == MoviePlayback && m.numPlayers > 0 {
+		e.SetNumPlayers(m.numPlayers)
+	}
+	m.reset()
+}
+
+func (m *movieState) reset() {
+	m.mode = MovieNone
+	m.movie = nil
+	m.loop = false
+	m.frame = 0
+	m.next = 0
+	m.nextKey = 0
+	m.keyEvents = nil
+	m.values = nil
+	m.frameValues = nil
+	m.numPlayers = 0
+}
+
+// Movie file layout, little-endian:
+//
+//	magic "CGMV", version u8, flags u8, players u8, frames u32,
+//	state length u32 and state if the state flag is set,
+//	event count u32, then for each event:
+//	frame delta from the previous event, port, device, index, id as uvarints and value as varint,
+//	keyboard event count u32, then for each keyboard event:
+//	frame delta from the previous keyboard event, down, keycode, character, modifiers as uvarints.
+//
+// Version 1 movies have no keyboard events.
+func (movie *Movie) Encode() []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	var flags uint8
+	if movie.State != nil {
+		flags |= movieFlagState
+	}
+
+	buf.WriteString(MOVIE_MAGIC)
+	buf.WriteByte(MOVIE_VERSION)
+	buf.WriteByte(flags)
+	buf.WriteByte(uint8(movie.NumPlayers))
+	buf.Write(binary.LittleEndian.AppendUint32(nil, movie.Frames))
+
+	if movie.State != nil {
+		buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(movie.State))))
+		buf.Write(movie.State)
+	}
+
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(movie.Events))))
+	var prev uint32
+	for _, ev := range movie.Events {
+		for _, v := range []uint64{
+			uint64(ev.Frame - prev),
+			uint64(ev.Input.Port),
+			uint64(ev.Input.Device),
+			uint64(ev.Input.Index),
+			uint64(ev.Input.ID),
+		} {
+			buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+		}
+		buf.Write(tmp[:binary.PutVarint(tmp[:], int64(ev.Value))])
+		prev = ev.Frame
+	}
+
+	buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(movie.KeyEvents))))
+	prev = 0
+	for _, ev := range movie.KeyEvents {
+		var down uint64
+		if ev.Event.Down {
+			down = 1
+		}
+		for _, v := range []uint64{
+			uint64(ev.Frame - prev),
+			down,
+			uint64(ev.Event.Keycode),
+			uint64(ev.Event.Character),
+			uint64(ev.Event.Modifiers),
+		} {
+			buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+		}
+		prev = ev.Frame
+	}
+
+	return buf.Bytes()
+}
+
+func DecodeMovie(data []byte) (*Movie, error) {
+	r := bytes.NewReader(data)
+
+	header := make([]byte, len(MOVIE_MAGIC)+3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(MOVIE_MAGIC)]) != MOVIE_MAGIC {
+		return nil, errors.New("invalid movie magic")
+	}
+	version := header[len(MOVIE_MAGIC)]
+	if version < 1 || version > MOVIE_VERSION {
+		return nil, errors.New("unsupported movie version")
+	}
+	flags := header[len(MOVIE_MAGIC)+1]
+
+	movie := &Movie{NumPlayers: uint(header[len(MOVIE_MAGIC)+2])}
+	if err := binary.Read(r, binary.LittleEndian, &movie.Frames); err != nil {
+		return nil, err
+	}
+
+	if flags&movieFlagState != 0 {
+		var size uint32
+		if err := binary.Read(r, binary.LittleEndian, &size); err != nil {
+			return nil, err
+		}
+		if int64(size) > int64(r.Len()) {
+			return nil, errors.New("invalid movie state size")
+		}
+		movie.State = make([]byte, size)
+		if _, err := io.ReadFull(r, movie.State); err != nil {
+			return nil, err
+		}
+	}
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	// each event takes at least 6 bytes
+	if int64(count)*6 > int64(r.Len()) {
+		return nil, errors.New("invalid movie event count")
+	}
+
+	movie.Events = make([]MovieEvent, 0, count)
+	var frame uint32
+	for i := uint32(0); i < count; i++ {
+		var fields [5]uint64
+		for j := range fields {
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			fields[j] = v
+		}
+
+		value, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		frame += uint32(fields[0])
+		movie.Events = append(movie.Events, MovieEvent{
+			Frame: frame,
+			Input: MovieInput{
+				Port:   uint8(fields[1]),
+				Device: uint8(fields[2]),
+				Index:  uint8(fields[3]),
+				ID:     uint16(fields[4]),
+			},
+			Value: int16(value),
+		})
+	}
+
+	if version < 2 {
+		return movie, nil
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	// each keyboard event takes at least 5 bytes
+	if int64(count)*5 > int64(r.Len()) {
+		return nil, errors.New("invalid movie keyboard event count")
+	}
+
+	movie.KeyEvents = make([]MovieKeyEvent, 0, count)
+	frame = 0
+	for i := uint32(0); i < count; i++ {
+		var fields [5]uint64
+		for j := range fields {
+			v, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			fields[j] = v
+		}
+
+		frame += uint32(fields[0])
+		movie.KeyEvents = append(movie.KeyEvents, MovieKeyEvent{
+			Frame: frame,
+			Event: KeyboardEvent{
+				Down:      fields[1] != 0,
+				Keycode:   uint(fields[2]),
+				Character: uint32(fields[3]),
+				Modifiers: uint16(fields[4]),
+			},
+		})
+	}
+
+	return movie, nil
+}
@@ -0,0 +1,81 @@
+package emulator
+
+import (
+	"reflect"
+	"testing"
+)
+
+var movieGolden = []struct {
+	name  string
+	movie *Movie
+}{
+	{
+		name:  "empty",
+		movie: &Movie{NumPlayers: 1, Events: []MovieEvent{}, KeyEvents: []MovieKeyEvent{}},
+	},
+	{
+		name: "power-on",
+		movie: &Movie{
+			NumPlayers: 2,
+			Frames:     600,
+			Events: []MovieEvent{
+				{Frame: 0, Input: MovieInput{Port: 0, Device: 1, Index: 0, ID: 8}, Value: 1},
+				{Frame: 0, Input: MovieInput{Port: 1, Device: 1, Index: 0, ID: 0}, Value: 1},
+				{Frame: 130, Input: MovieInput{Port: 0, Device: 1, Index: 0, ID: 8}, Value: 0},
+				{Frame: 599, Input: MovieInput{Port: 1, Device: 5, Index: 1, ID: 1}, Value: -32768},
+			},
+			KeyEvents: []MovieKeyEvent{
+				{Frame: 3, Event: KeyboardEvent{Down: true, Keycode: 97, Character: 'a'}},
+				{Frame: 5, Event: KeyboardEvent{Down: false, Keycode: 97, Character: 'a'}},
+				{Frame: 5, Event: KeyboardEvent{Down: true, Keycode: 303, Modifiers: 0x01}},
+			},
+		},
+	},
+	{
+		name: "from state",
+		movie: &Movie{
+			NumPlayers: 4,
+			State:      []byte{0x00, 0x01, 0xfe, 0xff},
+			Frames:     1 << 20,
+			Events: []MovieEvent{
+				{Frame: 1 << 19, Input: MovieInput{Port: 3, Device: 3, Index: 0, ID: 300}, Value: 32767},
+			},
+			KeyEvents: []MovieKeyEvent{},
+		},
+	},
+}
+
+func TestMovieRoundTrip(t *testing.T) {
+	for _, tc := range movieGolden {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := DecodeMovie(tc.movie.Encode())
+			if err != nil {
+				t.Fatalf("decode movie failed: %v", err)
+			}
+
+			if !reflect.DeepEqual(got, tc.movie) {
+				t.Errorf("got %+v, want %+v", got, tc.movie)
+			}
+		})
+	}
+}
+
+func TestDecodeMovieInvalid(t *testing.T) {
+	valid := movieGolden[1].movie.Encode()
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{name: "empty", data: nil},
+		{name: "magic", data: append([]byte("XXXX"), valid[4:]...)},
+		{name: "version", data: append(append([]byte(MOVIE_MAGIC), MOVIE_VERSION+1), valid[5:]...)},
+		{name: "truncated", data: valid[:len(valid)-1]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := DecodeMovie(tc.data); err == nil {
+				t.Error("decode movie succeeded")
+			}
+		})
+	}
+}
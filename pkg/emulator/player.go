@@ -11,11 +11,9 @@ type (
 		keyboard KeyBoard
 		retropad RetroPad
 		mouse    Mouse
-	}
+		analog   Analog
 
-	KeyBoard struct {
-		mu     sync.Mutex
-		states [libretro.NO_KB_KEYS]bool
+		mapping atomic.Pointer[InputMapping]
 	}
 
 	RetroPad struct {
@@ -23,16 +21,21 @@ type (
 		states [16]bool // only 16 standard buttons in retropad
 	}
 
-	Mouse struct {
-		dx, dy atomic.Int32
-		state  atomic.Int32
+	// Analog holds the left/right sticks in [-0x8000, 0x7fff]
+	// and the analog value of each retropad button (e.g: L2/R2 triggers) in [0, 0x7fff]
+	Analog struct {
+		sticks  [2][2]atomic.Int32 // [left, right][x, y]
+		buttons [16]atomic.Int32
 	}
 )
 
+var (
+	defaultInputMapping = DefaultInputMapping()
+)
+
 const (
-	LeftMouse int = iota + 1
-	MiddleMouse
-	RightMouse
+	AnalogMin int32 = -0x8000
+	AnalogMax int32 = 0x7fff
 )
 
 func (p *Player) GetKeyState(device uint32, index uint, id uint) int16 {
@@ -47,61 +50,90 @@ func (p *Player) GetKeyState(device uint32, index uint, id uint) int16 {
 			return 1
 		}
 		return 0
+	case libretro.ANALOG:
+		return p.getAnalogState(index, id)
+	case libretro.MOUSE:
+		return p.mouse.GetMouseState(id)
+	case libretro.LIGHTGUN:
+		return p.mouse.GetLightgunState(id)
+	case libretro.POINTER:
+		return p.mouse.GetPointerState(index, id)
 	default:
 		return 0
 	}
 }
 
-func (p *Player) SetKeyboardState(id uint, pressed bool) {
-	p.keyboard.SetState(id, pressed)
+func (p *Player) getAnalogState(index uint, id uint) int16 {
+	switch uint32(index) {
+	case libretro.DeviceIndexAnalogLeft, libretro.DeviceIndexAnalogRight:
+		return p.analog.GetAxis(index, id)
+	case libretro.DeviceIndexAnalogButton:
+		// fallback to the digital state when client doesn't send analog value for this button
+		if value := p.analog.GetButton(id); value != 0 {
+			return value
+		}
+		if p.retropad.GetState(id) {
+			return int16(AnalogMax)
+		}
+		return 0
+	default:
+		return 0
+	}
 }
 
-func (p *Player) SetMouseState(id uint) {
-	p.mouse.SetState(id)
+func (p *Player) GetInputMapping() *InputMapping {
+	if mapping := p.mapping.Load(); mapping != nil {
+		return mapping
+	}
+	return defaultInputMapping
 }
 
-func (p *Player) SetMousePos(x, y int) {
-	p.mouse.ShiftPosX(int32(x))
-	p.mouse.ShiftPosY(int32(y))
+func (p *Player) SetInputMapping(mapping *InputMapping) {
+	p.mapping.Store(mapping)
 }
 
-func (p *Player) SetRetroPadState(id uint, pressed bool) {
-	p.retropad.SetState(id, pressed)
+// SetKeyboardState updates the key and returns the modifiers after the update
+func (p *Player) SetKeyboardState(id uint, pressed bool) uint16 {
+	return p.keyboard.SetState(id, pressed)
 }
 
-func (kb *KeyBoard) GetState(id uint) bool {
-	return kb.states[id]
+// Poll snapshots the relative inputs accumulated since the previous poll
+func (p *Player) Poll() {
+	p.mouse.Poll()
 }
 
-func (kb *KeyBoard) SetState(id uint, pressed bool) {
-	kb.mu.Lock()
-	defer kb.mu.Unlock()
-	kb.states[id] = pressed
-
+func (p *Player) SetMouseButtons(buttons uint32) {
+	p.mouse.SetButtons(buttons)
 }
 
-func (m *Mouse) GetPosX() int32 {
-	return m.dx.Swap(0)
+func (p *Player) MoveMouse(dx, dy int32) {
+	p.mouse.Move(dx, dy)
 }
 
-func (m *Mouse) GetPosY() int32 {
-	return m.dy.Swap(0)
+func (p *Player) ScrollMouse(wheel, hwheel int32) {
+	p.mouse.Scroll(wheel, hwheel)
 }
 
-func (m *Mouse) ShiftPosX(dx int32) {
-	m.dx.Add(dx)
+func (p *Player) SetPointerPos(x, y int16, offscreen bool) {
+	p.mouse.SetPointerPos(x, y, offscreen)
 }
 
-func (m *Mouse) ShiftPosY(dy int32) {
-	m.dy.Add(dy)
+func (p *Player) SetRetroPadState(id uint, pressed bool) {
+	p.retropad.SetState(id, pressed)
 }
 
-func (m *Mouse) GetState(id uint) bool {
-	return m.state.Load() == int32(id)
+// SetRetroPadButtons sets all retropad buttons at once, bit i is the state of button id i
+func (p *Player) SetRetroPadButtons(mask uint16) {
+	p.retropad.SetMask(mask)
 }
 
-func (m *Mouse) SetState(id uint) {
-	m.state.Store(int32(id))
+func (p *Player) SetAnalogState(index uint, id uint, value int32) {
+	switch uint32(index) {
+	case libretro.DeviceIndexAnalogLeft, libretro.DeviceIndexAnalogRight:
+		p.analog.SetAxis(index, id, value)
+	case libretro.DeviceIndexAnalogButton:
+		p.analog.SetButton(id, value)
+	}
 }
 
 func (rp *RetroPad) GetState(id uint) bool {
@@ -113,3 +145,39 @@ func (rp *RetroPad) SetState(id uint, pressed bool) {
 	defer rp.mu.Unlock()
 	rp.states[id] = pressed
 }
+
+func (rp *RetroPad) SetMask(mask uint16) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for id := range rp.states {
+		rp.states[id] = mask&(1<<id) != 0
+	}
+}
+
+func (a *Analog) GetAxis(index uint, id uint) int16 {
+	if index >= 2 || id >= 2 {
+		return 0
+	}
+	return int16(a.sticks[index][id].Load())
+}
+
+func (a *Analog) SetAxis(index uint, id uint, value int32) {
+	if index >= 2 || id >= 2 {
+		return
+	}
+	a.sticks[index][id].Store(min(max(value, AnalogMin), AnalogMax))
+}
+
+func (a *Analog) GetButton(id uint) int16 {
+	if id >= uint(len(a.buttons)) {
+		return 0
+	}
+	return int16(a.buttons[id].Load())
+}
+
+func (a *Analog) SetButton(id uint, value int32) {
+	if id >= uint(len(a.buttons)) {
+		return
+	}
+	a.buttons[id].Store(min(max(value, 0), AnalogMax))
+}
@@ -0,0 +1,157 @@
+package emulator
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+type (
+	Pacing int
+
+	// frameScheduler paces frames on absolute deadlines computed from the start time,
+	// so that timer and frame duration errors do not accumulate over a long session
+	frameScheduler struct {
+		pacing   atomic.Int32
+		interval time.Duration
+
+		start     time.Time
+		frame     uint64
+		lastFrame time.Time
+		timer     *time.Timer
+
+		// audio frames produced by the core, used by PacingAudio
+		audioFrames atomic.Uint64
+		audioStart  uint64
+		sampleRate  float64
+
+		// stats, read from other goroutines
+		frames    atomic.Uint64
+		late      atomic.Uint64
+		jitter    atomic.Int64 // smoothed, in ns
+		maxJitter atomic.Int64 // in ns
+	}
+
+	FrameStats struct {
+		Frames    uint64        // frames run since the game started
+		Late      uint64        // times the scheduler fell too far behind and gave up catching up
+		Jitter    time.Duration // smoothed deviation of the frame interval from the expected one
+		MaxJitter time.Duration
+	}
+)
+
+const (
+	// PacingVideo runs frames at the core's fps
+	PacingVideo Pacing = iota
+	// PacingAudio runs frames so that the produced audio matches the wall clock,
+	// falls back to PacingVideo until the core produces audio
+	PacingAudio
+)
+
+const (
+	// frames the scheduler may run back to back to catch up before resyncing to the current time
+	MAX_FRAME_LAG = 5
+)
+
+// Start resets the schedule, the first frame runs immediately
+func (s *frameScheduler) Start(fps, sampleRate float64) {
+	now := time.Now()
+
+	s.interval = time.Duration(float64(time.Second) / fps)
+	s.sampleRate = sampleRate
+	s.start = now
+	s.frame = 0
+	s.lastFrame = time.Time{}
+	s.audioStart = s.audioFrames.Load()
+
+	s.frames.Store(0)
+	s.late.Store(0)
+	s.jitter.Store(0)
+	s.maxJitter.Store(0)
+}
+
+// SetTiming changes the frame rate and sample rate without restarting the stats
+func (s *frameScheduler) SetTiming(fps, sampleRate float64) {
+	s.start = s.deadline()
+	s.frame = 0
+	s.audioStart = s.audioFrames.Load()
+	s.interval = time.Duration(float64(time.Second) / fps)
+	s.sampleRate = sampleRate
+}
+
+func (s *frameScheduler) SetPacing(pacing Pacing) {
+	s.pacing.Store(int32(pacing))
+}
+
+func (s *frameScheduler) AddAudioFrames(n int32) {
+	s.audioFrames.Add(uint64(n))
+}
+
+// deadline returns when the next frame should start
+func (s *frameScheduler) deadline() time.Time {
+	if Pacing(s.pacing.Load()) == PacingAudio && s.sampleRate > 0 {
+		if n := s.audioFrames.Load() - s.audioStart; n > 0 {
+			return s.start.Add(time.Duration(float64(n) / s.sampleRate * float64(time.Second)))
+		}
+	}
+
+	return s.start.Add(time.Duration(s.frame) * s.interval)
+}
+
+// Wait sleeps until the next frame should start
+func (s *frameScheduler) Wait() {
+	deadline := s.deadline()
+
+	if d := time.Until(deadline); d > 0 {
+		if s.timer == nil {
+			s.timer = time.NewTimer(d)
+		} else {
+			s.timer.Reset(d)
+		}
+		<-s.timer.C
+	}
+
+	now := time.Now()
+	if now.Sub(deadline) > MAX_FRAME_LAG*s.interval {
+		// e.g: the process was suspended, running every missed frame would only speed up the game
+		s.late.Add(1)
+		s.start = now
+		s.frame = 0
+		s.audioStart = s.audioFrames.Load()
+	}
+
+	s.updateJitter(now)
+	s.frame++
+	s.frames.Add(1)
+}
+
+// updateJitter follows RFC 3550 jitter estimation: J += (|D| - J) / 16
+func (s *frameScheduler) updateJitter(now time.Time) {
+	if !s.lastFrame.IsZero() {
+		d := now.Sub(s.lastFrame) - s.interval
+		if d < 0 {
+			d = -d
+		}
+
+		j := s.jitter.Load()
+		s.jitter.Store(j + (int64(d)-j)/16)
+		if int64(d) > s.maxJitter.Load() {
+			s.maxJitter.Store(int64(d))
+		}
+	}
+	s.lastFrame = now
+}
+
+func (s *frameScheduler) Stop() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+func (s *frameScheduler) Stats() FrameStats {
+	return FrameStats{
+		Frames:    s.frames.Load(),
+		Late:      s.late.Load(),
+		Jitter:    time.Duration(s.jitter.Load()),
+		MaxJitter: time.Duration(s.maxJitter.Load()),
+	}
+}
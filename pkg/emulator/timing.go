@@ -0,0 +1,87 @@
+package emulator
+
+import (
+	"time"
+	"unsafe"
+)
+
+const (
+	DEFAULT_AUDIO_CALLBACK_INTERVAL = 10 * time.Millisecond
+
+	// audio callbacks invoked at most between two frames to catch up
+	MAX_AUDIO_CALLBACKS_PER_FRAME = 8
+)
+
+func (e *Emulator) SetFrameTimeCallback(data unsafe.Pointer) {
+	e.core.SetFrameTimeCallback(data)
+}
+
+func (e *Emulator) SetAudioCallback(data unsafe.Pointer) {
+	e.core.SetAudioCallback(data)
+}
+
+// SetAudioCallbackInterval sets how much audio cores using the audio callback are asked for ahead,
+// it should match the duration of the audio pipeline's packets
+func (e *Emulator) SetAudioCallbackInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = DEFAULT_AUDIO_CALLBACK_INTERVAL
+	}
+	e.audioInterval = interval
+}
+
+// callFrameTime tells the core how much time passed since the previous frame, right before it runs.
+// The reference frame time is used while a movie is in progress to keep it reproducible.
+func (e *Emulator) callFrameTime() {
+	ftc := e.core.FrameTimeCallback
+	if ftc == nil {
+		return
+	}
+
+	now := time.Now()
+	usec := ftc.Reference
+	if !e.lastFrameTime.IsZero() && e.GetMovieStatus().Mode == MovieNone {
+		usec = now.Sub(e.lastFrameTime).Microseconds()
+	}
+	e.lastFrameTime = now
+
+	ftc.Callback(usec)
+}
+
+// startAudioCallback enables the audio callback of cores using it, the callback is then
+// run by the emulator thread between frames so that the audio pipeline is only fed from that thread
+func (e *Emulator) startAudioCallback() {
+	auc := e.core.AudioCallback
+	if auc == nil || e.systemInfo.Timing.SampleRate <= 0 {
+		return
+	}
+
+	e.audioStart = time.Now()
+	e.audioStartFrames = e.scheduler.audioFrames.Load()
+	auc.SetState(true)
+}
+
+// runAudioCallback calls the core as long as the audio it produced is behind the wall clock,
+// one interval ahead since the next call only comes after the next frame
+func (e *Emulator) runAudioCallback() {
+	if e.audioStart.IsZero() {
+		return
+	}
+
+	elapsed := time.Since(e.audioStart) + e.audioInterval
+	expected := uint64(elapsed.Seconds() * e.systemInfo.Timing.SampleRate)
+	for i := 0; i < MAX_AUDIO_CALLBACKS_PER_FRAME; i++ {
+		if e.scheduler.audioFrames.Load()-e.audioStartFrames >= expected {
+			break
+		}
+		e.core.AudioCallback.Callback()
+	}
+}
+
+func (e *Emulator) stopAudioCallback() {
+	if e.audioStart.IsZero() {
+		return
+	}
+
+	e.audioStart = time.Time{}
+	e.core.AudioCallback.SetState(false)
+}
@@ -0,0 +1,84 @@
+package encoder
+
+import (
+	"cloud_gaming/pkg/ffmpeg/video"
+	"fmt"
+	"strconv"
+)
+
+type (
+	// AV1Encoder is a software encoder, SVT-AV1 is much faster than libaom
+	AV1Encoder struct {
+		ffmpegEncoder
+	}
+)
+
+func init() {
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libsvtav1",
+		Codec: video.AV1,
+		Probe: probeFFmpegEncoder("libsvtav1"),
+		New:   NewSVTAV1Encoder,
+	})
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libaom-av1",
+		Codec: video.AV1,
+		Probe: probeFFmpegEncoder("libaom-av1"),
+		New:   NewAOMAV1Encoder,
+	})
+}
+
+func NewSVTAV1Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	return newAV1Encoder("libsvtav1", map[string]string{
+		"crf":    strconv.Itoa(o.CRF), // 0-63 bigger means smaller size but lower quality
+		"preset": "12",                // 0-13 bigger means higher speed but lower quality
+	}, o)
+}
+
+func NewAOMAV1Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	return newAV1Encoder("libaom-av1", map[string]string{
+		"crf":      strconv.Itoa(o.CRF),
+		"usage":    "realtime",
+		"cpu-used": "8", // 0-8 bigger means higher speed but lower quality
+		"row-mt":   "1",
+	}, o)
+}
+
+func newAV1Encoder(name string, dict map[string]string, o VideoEncoderOptions) (IVideoEncoder, error) {
+	codec, err := video.NewCodecByName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	codecCtx, err := video.NewCodecCtx(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []video.CodecCtxOption{
+		video.SetBitrate(o.Bitrate),
+		video.SetWidth(o.Width),
+		video.SetHeight(o.Height),
+		video.SetTimebase(*video.NewRational(1, o.FPS)),
+		video.SetPixelFormat(int(o.PixFmt)),
+		video.SetGopSize(gopSize(o.FPS)),
+		video.SetMaxBFrames(0),
+		video.SetThreadCount(10),
+	}
+
+	err = video.OpenContext(codecCtx, codec, video.NewDictionary(dict), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("create %s encoder failed: %w", name, err)
+	}
+
+	enc := &AV1Encoder{
+		ffmpegEncoder: newFFmpegEncoder(name, codecCtx),
+	}
+
+	return enc, nil
+}
+
+// SetBitrate is not supported, both encoders only read the bitrate when they are opened
+func (e *AV1Encoder) SetBitrate(bitrate int) error {
+	return ErrNotSupported
+}
@@ -2,17 +2,97 @@ package encoder
 
 import (
 	"cloud_gaming/pkg/ffmpeg/video"
+	"errors"
+	"sync/atomic"
+	"time"
 )
 
 type (
 	IVideoEncoder interface {
-		Encode(*video.AVFrame) error
-		GetEncodedData() ([]byte, error)
+		// Encode takes the presentation time of the frame on the session timeline,
+		// the packets it produces are delivered on Packets before it returns
+		Encode(frame *video.AVFrame, pts time.Duration) error
+		// Packets delivers the encoded frames in order, it is closed by Close
+		Packets() <-chan VideoPacket
+		// SetBitrate changes the bitrate without restarting the encoder,
+		// ErrNotSupported means that the encoder has to be recreated instead
+		SetBitrate(bitrate int) error
+		// RequestKeyFrame makes the next encoded frame a keyframe, e.g: after a packet loss
+		RequestKeyFrame()
 		Close() error
 	}
 
+	// VideoPacket is an encoded frame, PTS is the presentation time given to Encode
+	VideoPacket struct {
+		Data []byte
+		PTS  time.Duration
+	}
+
 	IAudioEncoder interface {
 		Encode([]int16) ([]byte, error)
 		Close() error
 	}
+
+	VideoEncoderOptions struct {
+		Width   int
+		Height  int
+		FPS     int
+		PixFmt  video.PixelFormat
+		Bitrate int // bits per second
+		CRF     int // constant rate factor, bigger means smaller size but lower quality
+	}
 )
+
+var ErrNotSupported = errors.New("not supported by encoder")
+
+const (
+	// decoders recover from losses with keyframes requested by the clients,
+	// periodic keyframes are only a fallback
+	KEYFRAME_INTERVAL_SECONDS = 10
+)
+
+func gopSize(fps int) int {
+	return fps * KEYFRAME_INTERVAL_SECONDS
+}
+
+// vbvBufferSize keeps half a second of data in the rate control buffer, a smaller buffer adapts faster
+func vbvBufferSize(bitrate int) int {
+	return bitrate / 2
+}
+
+// keyFramePictureType consumes a keyframe request, frames are reused so the type is always set
+func keyFramePictureType(forceKeyFrame *atomic.Bool) video.PictureType {
+	if !forceKeyFrame.Swap(false) {
+		return video.PictureTypeNone
+	}
+	return video.PictureTypeI
+}
+
+// ptsMap carries presentation times through an encoder, frames are numbered in the codec timebase
+// and the number comes back in the packet pts
+type ptsMap struct {
+	next int64
+	pts  map[int64]time.Duration
+}
+
+func (m *ptsMap) Push(pts time.Duration) int64 {
+	if m.pts == nil {
+		m.pts = make(map[int64]time.Duration)
+	}
+
+	n := m.next
+	m.next++
+	m.pts[n] = pts
+	return n
+}
+
+// Pop also forgets older frames, that the encoder skipped
+func (m *ptsMap) Pop(n int64) time.Duration {
+	pts := m.pts[n]
+	for k := range m.pts {
+		if k <= n {
+			delete(m.pts, k)
+		}
+	}
+	return pts
+}
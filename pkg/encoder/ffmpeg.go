@@ -0,0 +1,154 @@
+package encoder
+
+import (
+	"cloud_gaming/pkg/ffmpeg/utils"
+	"cloud_gaming/pkg/ffmpeg/video"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// ffmpegEncoder is the part shared by the encoders built on an ffmpeg codec context,
+	// packets are received right after each frame is sent and delivered on a channel
+	ffmpegEncoder struct {
+		// codec context cannot be accessed concurrently but sequentially
+		mu       sync.Mutex
+		codecCtx *video.CodecCtx
+
+		name           string
+		isShuttingDown bool
+		forceKeyFrame  atomic.Bool
+		pts            ptsMap
+
+		// sendMu orders the deliveries of Encode with the closing of packets, mu is never held
+		// while waiting for the consumer so that RequestKeyFrame, SetBitrate and Close never wait for it
+		sendMu  sync.Mutex
+		packets chan VideoPacket
+		done    chan struct{}
+	}
+)
+
+const (
+	// PACKET_BUFFER_SIZE is how many encoded frames may wait for the consumer, Encode blocks past it
+	PACKET_BUFFER_SIZE = 8
+)
+
+func newFFmpegEncoder(name string, codecCtx *video.CodecCtx) ffmpegEncoder {
+	return ffmpegEncoder{
+		name:     name,
+		codecCtx: codecCtx,
+		packets:  make(chan VideoPacket, PACKET_BUFFER_SIZE),
+		done:     make(chan struct{}),
+	}
+}
+
+func (e *ffmpegEncoder) Encode(videoFrame *video.AVFrame, pts time.Duration) error {
+	pkts, err := e.encode(videoFrame, pts)
+	e.deliver(pkts)
+	return err
+}
+
+func (e *ffmpegEncoder) encode(videoFrame *video.AVFrame, pts time.Duration) ([]VideoPacket, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// is shutting down
+	if !e.isRunning() {
+		return nil, fmt.Errorf("%s encoder is shutting down", e.name)
+	}
+
+	videoFrame.SetPTS(e.pts.Push(pts))
+	videoFrame.SetPictureType(keyFramePictureType(&e.forceKeyFrame))
+	if err := video.EncodeFrame(e.codecCtx, videoFrame); err != nil {
+		return nil, err
+	}
+
+	return e.receivePackets()
+}
+
+// receivePackets collects every packet the encoder has ready, e.mu must be held
+func (e *ffmpegEncoder) receivePackets() ([]VideoPacket, error) {
+	var pkts []VideoPacket
+	for {
+		pkt, err := video.GetEncodedPacket(e.codecCtx)
+		if err != nil {
+			return pkts, err
+		}
+
+		if pkt == nil {
+			return pkts, nil
+		}
+
+		pkts = append(pkts, VideoPacket{
+			Data: utils.PointerToSlice(pkt.GetData(), pkt.GetSize()),
+			PTS:  e.pts.Pop(pkt.GetPTS()),
+		})
+		pkt.Close()
+	}
+}
+
+// deliver blocks while the consumer is behind, the packets are dropped once the encoder is closed
+func (e *ffmpegEncoder) deliver(pkts []VideoPacket) {
+	if len(pkts) == 0 {
+		return
+	}
+
+	e.sendMu.Lock()
+	defer e.sendMu.Unlock()
+
+	// packets is only closed after done, under sendMu
+	select {
+	case <-e.done:
+		return
+	default:
+	}
+
+	for _, pkt := range pkts {
+		select {
+		case e.packets <- pkt:
+		case <-e.done:
+			return
+		}
+	}
+}
+
+func (e *ffmpegEncoder) Packets() <-chan VideoPacket {
+	return e.packets
+}
+
+func (e *ffmpegEncoder) RequestKeyFrame() {
+	e.forceKeyFrame.Store(true)
+}
+
+func (e *ffmpegEncoder) stopping() {
+	e.isShuttingDown = true
+}
+
+func (e *ffmpegEncoder) isRunning() bool {
+	return !e.isShuttingDown
+}
+
+// Close drops the frames still in the encoder and closes the packet channel,
+// nothing is sent on it afterwards so the consumer only has to range over it
+func (e *ffmpegEncoder) Close() error {
+	e.mu.Lock()
+	if !e.isRunning() {
+		e.mu.Unlock()
+		return nil
+	}
+
+	e.stopping()
+	// a pending delivery gives up
+	close(e.done)
+
+	err := video.Flush(e.codecCtx)
+	e.codecCtx.Free()
+	e.mu.Unlock()
+
+	e.sendMu.Lock()
+	close(e.packets)
+	e.sendMu.Unlock()
+	return err
+}
@@ -1,27 +1,58 @@
 package encoder
 
 import (
-	"cloud_gaming/pkg/ffmpeg/utils"
 	"cloud_gaming/pkg/ffmpeg/video"
-	"errors"
 	"fmt"
-	"sync"
+	"strconv"
 )
 
 type (
 	H264Encoder struct {
-		// codec context cannot be accessed concurrently but sequentially
-		mu       sync.Mutex
-		codecCtx *video.CodecCtx
+		ffmpegEncoder
 
-		isShuttingDown bool
-
-		totalFrames int
+		// libx264 reconfigures its rate control on the fly, openh264 does not
+		dynamicBitrate bool
 	}
 )
 
-func NewH264Encoder(width, height, fps int, pixFmt video.PixelFormat) (IVideoEncoder, error) {
-	codec, err := video.NewCodec(video.H264)
+func init() {
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libx264",
+		Codec: video.H264,
+		Probe: probeFFmpegEncoder("libx264"),
+		New:   NewH264Encoder,
+	})
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libopenh264",
+		Codec: video.H264,
+		Probe: probeFFmpegEncoder("libopenh264"),
+		New:   NewOpenH264Encoder,
+	})
+}
+
+// NewH264Encoder uses libx264
+func NewH264Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	dict := map[string]string{
+		"crf":        strconv.Itoa(o.CRF),
+		"preset":     "superfast",
+		"forced-idr": "1", // forced keyframes are IDR frames, so that a new decoder can start from them
+	}
+
+	return newH264Encoder("libx264", dict, true, o, video.SetProfile(video.MainProfile), video.SetLevel(40))
+}
+
+// NewOpenH264Encoder uses Cisco's openh264, which has no crf and only follows the bitrate
+func NewOpenH264Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	dict := map[string]string{
+		"rc_mode":           "bitrate",
+		"allow_skip_frames": "1",
+	}
+
+	return newH264Encoder("libopenh264", dict, false, o, video.SetProfile(video.BaseProfile))
+}
+
+func newH264Encoder(name string, dict map[string]string, dynamicBitrate bool, o VideoEncoderOptions, extra ...video.CodecCtxOption) (IVideoEncoder, error) {
+	codec, err := video.NewCodecByName(name)
 	if err != nil {
 		return nil, err
 	}
@@ -31,93 +62,47 @@ func NewH264Encoder(width, height, fps int, pixFmt video.PixelFormat) (IVideoEnc
 		return nil, err
 	}
 
-	dict := video.NewDictionary(map[string]string{
-		"crf":    "23",
-		"preset": "superfast",
-	})
-
 	opts := []video.CodecCtxOption{
-		video.SetBitrate(2000000),
-		video.SetWidth(width),
-		video.SetHeight(height),
-		video.SetTimebase(*video.NewRational(1, fps)),
-		video.SetPixelFormat(int(pixFmt)),
-		video.SetGopSize(fps / 2),
+		video.SetBitrate(o.Bitrate),
+		video.SetMaxRate(o.Bitrate),
+		video.SetBufferSize(vbvBufferSize(o.Bitrate)),
+		video.SetWidth(o.Width),
+		video.SetHeight(o.Height),
+		video.SetTimebase(*video.NewRational(1, o.FPS)),
+		video.SetPixelFormat(int(o.PixFmt)),
+		video.SetGopSize(gopSize(o.FPS)),
 		video.SetMaxBFrames(0),
 		video.SetThreadCount(10),
 		video.SetThreadType(video.ThreadFrame),
 		video.SetSkipFrame(video.DiscardNonRef),
-		video.SetProfile(video.MainProfile),
-		video.SetLevel(40),
 	}
 
-	err = video.OpenContext(codecCtx, codec, dict, opts...)
+	err = video.OpenContext(codecCtx, codec, video.NewDictionary(dict), append(opts, extra...)...)
 	if err != nil {
-		return nil, fmt.Errorf("create h264 encoder failed: %w", err)
+		return nil, fmt.Errorf("create %s encoder failed: %w", name, err)
 	}
 
 	enc := &H264Encoder{
-		codecCtx: codecCtx,
+		ffmpegEncoder:  newFFmpegEncoder(name, codecCtx),
+		dynamicBitrate: dynamicBitrate,
 	}
 
 	return enc, nil
 }
 
-func (e *H264Encoder) Encode(videoFrame *video.AVFrame) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// is shutting down
-	if !e.isRunning() {
-		return nil
+// SetBitrate caps the bitrate through the rate control buffer, crf keeps driving the quality below the cap
+func (e *H264Encoder) SetBitrate(bitrate int) error {
+	if !e.dynamicBitrate {
+		return ErrNotSupported
 	}
 
-	// only need it to be strictly increasing, value does not matter
-	videoFrame.SetPTS(int64(e.totalFrames))
-	e.totalFrames += 1
-	return video.EncodeFrame(e.codecCtx, videoFrame)
-}
-
-func (e *H264Encoder) GetEncodedData() ([]byte, error) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	// is shutting down
 	if !e.isRunning() {
-		return nil, errors.New("h264 encoder is shutting down")
-	}
-
-	pkt, err := video.GetEncodedPacket(e.codecCtx)
-	if err != nil {
-		return nil, err
-	}
-	defer pkt.Close()
-
-	if pkt == nil {
-		return nil, nil
-	}
-
-	return utils.PointerToSlice(pkt.GetData(), pkt.GetSize()), nil
-}
-
-func (e *H264Encoder) stopping() {
-	e.isShuttingDown = true
-}
-
-func (e *H264Encoder) isRunning() bool {
-	return !e.isShuttingDown
-}
-
-func (e *H264Encoder) Close() error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	e.stopping()
-
-	if err := video.Flush(e.codecCtx); err != nil {
-		return err
+		return nil
 	}
 
-	e.codecCtx.Free()
+	e.codecCtx.UpdateBitrate(bitrate, vbvBufferSize(bitrate))
 	return nil
 }
@@ -1,7 +1,10 @@
 package encoder
 
 import (
+	"errors"
 	"fmt"
+	"slices"
+	"time"
 
 	"gopkg.in/hraban/opus.v2"
 )
@@ -11,35 +14,103 @@ type (
 		sampleRate int
 		encoder    *opus.Encoder
 		channel    int
+
+		// reused by each packet, libopus never produces more than MAX_OPUS_PACKET_SIZE bytes
+		buffer []byte
 	}
+
+	OpusApplication = opus.Application
+	OpusBandwidth   = opus.Bandwidth
+
+	OpusOptions struct {
+		SampleRate    int
+		Channels      int
+		Application   OpusApplication
+		Bitrate       int           // bits per second
+		FrameDuration time.Duration // 2.5, 5, 10, 20, 40 or 60 ms
+		Complexity    int           // 0-10 bigger means higher quality but more cpu
+		PacketLoss    int           // expected packet loss in percent, makes in-band FEC more robust
+		MaxBandwidth  OpusBandwidth
+		FEC           bool // in-band forward error correction, only with OpusAppVoIP and OpusAppAudio
+		DTX           bool // discontinuous transmission, silence is barely sent
+	}
+)
+
+const (
+	OpusAppVoIP     = opus.AppVoIP
+	OpusAppAudio    = opus.AppAudio
+	OpusAppLowDelay = opus.AppRestrictedLowdelay
+
+	OpusWideband      = opus.Wideband
+	OpusSuperWideband = opus.SuperWideband
+	OpusFullband      = opus.Fullband
+)
+
+const (
+	// recommended by libopus for a packet of up to 120 ms
+	MAX_OPUS_PACKET_SIZE = 4000
+	MAX_OPUS_COMPLEXITY  = 10
 )
 
-func NewOpusEncoder(sampleRate, channel int) (IAudioEncoder, error) {
-	encoder, err := opus.NewEncoder(sampleRate, channel, opus.AppRestrictedLowdelay)
+var opusFrameDurations = []time.Duration{
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	20 * time.Millisecond,
+	40 * time.Millisecond,
+	60 * time.Millisecond,
+}
+
+// IsOpusFrameDuration reports whether opus encodes packets of duration d
+func IsOpusFrameDuration(d time.Duration) bool {
+	return slices.Contains(opusFrameDurations, d)
+}
+
+func NewOpusEncoder(o OpusOptions) (IAudioEncoder, error) {
+	if !IsOpusFrameDuration(o.FrameDuration) {
+		return nil, errors.New("invalid opus frame duration")
+	}
+	if o.Complexity < 0 || o.Complexity > MAX_OPUS_COMPLEXITY {
+		return nil, errors.New("invalid opus complexity")
+	}
+	if o.PacketLoss < 0 || o.PacketLoss > 100 {
+		return nil, errors.New("invalid opus packet loss")
+	}
+
+	encoder, err := opus.NewEncoder(o.SampleRate, o.Channels, o.Application)
 	if err != nil {
 		return nil, fmt.Errorf("create opus encoder failed: %w", err)
 	}
 
-	encoder.SetDTX(true)
-	encoder.SetInBandFEC(true)
-	encoder.SetBitrate(96000)
-	encoder.SetMaxBandwidth(opus.Fullband)
+	for _, err := range []error{
+		encoder.SetDTX(o.DTX),
+		encoder.SetInBandFEC(o.FEC),
+		encoder.SetPacketLossPerc(o.PacketLoss),
+		encoder.SetBitrate(o.Bitrate),
+		encoder.SetComplexity(o.Complexity),
+		encoder.SetMaxBandwidth(o.MaxBandwidth),
+	} {
+		if err != nil {
+			return nil, fmt.Errorf("configure opus encoder failed: %w", err)
+		}
+	}
 
 	return &OpusEncoder{
 		encoder:    encoder,
-		sampleRate: sampleRate,
-		channel:    channel,
+		sampleRate: o.SampleRate,
+		channel:    o.Channels,
+		buffer:     make([]byte, MAX_OPUS_PACKET_SIZE),
 	}, nil
 }
 
+// Encode returns a packet that is only valid until the next call
 func (e *OpusEncoder) Encode(pcm []int16) ([]byte, error) {
-	buffer := make([]byte, 1024)
-	n, err := e.encoder.Encode(pcm, buffer)
+	n, err := e.encoder.Encode(pcm, e.buffer)
 	if err != nil {
 		return nil, fmt.Errorf("opus encode failed: %w", err)
 	}
 
-	return buffer[:n], nil
+	return e.buffer[:n], nil
 }
 
 func (e *OpusEncoder) Close() error {
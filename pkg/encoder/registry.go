@@ -0,0 +1,91 @@
+package encoder
+
+import (
+	"cloud_gaming/pkg/ffmpeg/video"
+	"slices"
+	"sync"
+)
+
+type (
+	NewVideoEncoderFunc func(VideoEncoderOptions) (IVideoEncoder, error)
+
+	// VideoEncoderInfo describes an implementation of IVideoEncoder,
+	// Name is the ffmpeg encoder name, e.g: "libx264"
+	VideoEncoderInfo struct {
+		Name  string
+		Codec video.VideoCodec
+		// Probe tells if the encoder can be used, e.g: ffmpeg was built with it
+		Probe func() bool
+		New   NewVideoEncoderFunc
+	}
+
+	videoEncoderEntry struct {
+		VideoEncoderInfo
+
+		once      sync.Once
+		available bool
+	}
+)
+
+var videoEncoders struct {
+	mu      sync.Mutex
+	entries []*videoEncoderEntry
+}
+
+// RegisterVideoEncoder is called from init by each implementation, a name registered twice replaces the first one
+func RegisterVideoEncoder(info VideoEncoderInfo) {
+	videoEncoders.mu.Lock()
+	defer videoEncoders.mu.Unlock()
+
+	entry := &videoEncoderEntry{VideoEncoderInfo: info}
+	for i, e := range videoEncoders.entries {
+		if e.Name == info.Name {
+			videoEncoders.entries[i] = entry
+			return
+		}
+	}
+	videoEncoders.entries = append(videoEncoders.entries, entry)
+}
+
+// VideoEncoders returns the available encoders of codec in the order of names,
+// every registered encoder in registration order if names is empty
+func VideoEncoders(codec video.VideoCodec, names []string) []VideoEncoderInfo {
+	videoEncoders.mu.Lock()
+	entries := slices.Clone(videoEncoders.entries)
+	videoEncoders.mu.Unlock()
+
+	if len(names) == 0 {
+		for _, e := range entries {
+			names = append(names, e.Name)
+		}
+	}
+
+	var infos []VideoEncoderInfo
+	for _, name := range names {
+		i := slices.IndexFunc(entries, func(e *videoEncoderEntry) bool { return e.Name == name })
+		if i < 0 {
+			continue
+		}
+
+		if e := entries[i]; e.Codec == codec && e.isAvailable() {
+			infos = append(infos, e.VideoEncoderInfo)
+		}
+	}
+	return infos
+}
+
+// isAvailable probes the encoder once
+func (e *videoEncoderEntry) isAvailable() bool {
+	e.once.Do(func() {
+		e.available = e.Probe == nil || e.Probe()
+	})
+	return e.available
+}
+
+// probeFFmpegEncoder returns a probe checking that ffmpeg has the encoder
+func probeFFmpegEncoder(name string) func() bool {
+	return func() bool {
+		_, err := video.NewCodecByName(name)
+		return err == nil
+	}
+}
@@ -1,25 +1,49 @@
 package encoder
 
 import (
-	"cloud_gaming/pkg/ffmpeg/utils"
 	"cloud_gaming/pkg/ffmpeg/video"
-	"errors"
 	"fmt"
-	"sync"
+	"strconv"
 )
 
 type (
-	VP9Encoder struct {
-		// codec context cannot be accessed concurrently but sequentially
-		mu       sync.Mutex
-		codecCtx *video.CodecCtx
-
-		isShuttingDown bool
+	// VPXEncoder encodes VP8 or VP9 with libvpx
+	VPXEncoder struct {
+		ffmpegEncoder
 	}
 )
 
-func NewVP9Encoder(width, height, fps int, pixFmt video.PixelFormat) (IVideoEncoder, error) {
-	codec, err := video.NewCodec(video.VP9)
+const (
+	// libvpx rejects a lower crf for vp8
+	VP8_MIN_CRF = 4
+)
+
+func init() {
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libvpx-vp9",
+		Codec: video.VP9,
+		Probe: probeFFmpegEncoder("libvpx-vp9"),
+		New:   NewVP9Encoder,
+	})
+	RegisterVideoEncoder(VideoEncoderInfo{
+		Name:  "libvpx",
+		Codec: video.VP8,
+		Probe: probeFFmpegEncoder("libvpx"),
+		New:   NewVP8Encoder,
+	})
+}
+
+func NewVP8Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	o.CRF = max(o.CRF, VP8_MIN_CRF)
+	return newVPXEncoder("libvpx", o)
+}
+
+func NewVP9Encoder(o VideoEncoderOptions) (IVideoEncoder, error) {
+	return newVPXEncoder("libvpx-vp9", o)
+}
+
+func newVPXEncoder(name string, o VideoEncoderOptions) (IVideoEncoder, error) {
+	codec, err := video.NewCodecByName(name)
 	if err != nil {
 		return nil, err
 	}
@@ -30,18 +54,18 @@ func NewVP9Encoder(width, height, fps int, pixFmt video.PixelFormat) (IVideoEnco
 	}
 
 	dict := video.NewDictionary(map[string]string{
-		"crf":      "23", // 0-63 bigger means smaller size but lower quality
-		"cpu-used": "5",  // 0-8 bigger means higher speed but lower quality and compression
+		"crf":      strconv.Itoa(o.CRF), // 0-63 bigger means smaller size but lower quality
+		"cpu-used": "5",                 // 0-8 bigger means higher speed but lower quality and compression
 		"preset":   "superfast",
 	})
 
 	opts := []video.CodecCtxOption{
-		video.SetBitrate(2000000),
-		video.SetWidth(width),
-		video.SetHeight(height),
-		video.SetTimebase(*video.NewRational(1, fps)),
-		video.SetPixelFormat(int(pixFmt)),
-		video.SetGopSize(fps / 2),
+		video.SetBitrate(o.Bitrate),
+		video.SetWidth(o.Width),
+		video.SetHeight(o.Height),
+		video.SetTimebase(*video.NewRational(1, o.FPS)),
+		video.SetPixelFormat(int(o.PixFmt)),
+		video.SetGopSize(gopSize(o.FPS)),
 		video.SetMaxBFrames(0),
 		video.SetThreadCount(10),
 		video.SetThreadType(video.ThreadFrame),
@@ -50,68 +74,17 @@ func NewVP9Encoder(width, height, fps int, pixFmt video.PixelFormat) (IVideoEnco
 
 	err = video.OpenContext(codecCtx, codec, dict, opts...)
 	if err != nil {
-		return nil, fmt.Errorf("create vp9 encoder failed: %w", err)
+		return nil, fmt.Errorf("create %s encoder failed: %w", name, err)
 	}
 
-	enc := &VP9Encoder{
-		codecCtx: codecCtx,
+	enc := &VPXEncoder{
+		ffmpegEncoder: newFFmpegEncoder(name, codecCtx),
 	}
 
 	return enc, nil
 }
 
-func (e *VP9Encoder) Encode(videoFrame *video.AVFrame) error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// is shutting down
-	if !e.isRunning() {
-		return errors.New("vp9 encoder is shutting down")
-	}
-
-	return video.EncodeFrame(e.codecCtx, videoFrame)
-}
-
-func (e *VP9Encoder) GetEncodedData() ([]byte, error) {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	// is shutting down
-	if !e.isRunning() {
-		return nil, errors.New("vp9 encoder is shutting down")
-	}
-
-	pkt, err := video.GetEncodedPacket(e.codecCtx)
-	if err != nil {
-		return nil, err
-	}
-	defer pkt.Close()
-
-	if pkt == nil {
-		return nil, nil
-	}
-
-	return utils.PointerToSlice(pkt.GetData(), pkt.GetSize()), nil
-}
-
-func (e *VP9Encoder) stopping() {
-	e.isShuttingDown = true
-}
-
-func (e *VP9Encoder) isRunning() bool {
-	return !e.isShuttingDown
-}
-
-func (e *VP9Encoder) Close() error {
-	e.mu.Lock()
-	defer e.mu.Unlock()
-
-	e.stopping()
-
-	if err := video.Flush(e.codecCtx); err != nil {
-		return err
-	}
-
-	e.codecCtx.Free()
-	return nil
+// SetBitrate is not supported, libvpx only reads the bitrate when the encoder is opened
+func (e *VPXEncoder) SetBitrate(bitrate int) error {
+	return ErrNotSupported
 }
@@ -4,12 +4,14 @@ package video
 #cgo pkg-config: libavcodec libavutil
 #include <libavcodec/avcodec.h>
 #include <libavutil/dict.h>
+#include <stdlib.h>
 */
 import "C"
 import (
 	"cloud_gaming/pkg/ffmpeg/utils"
 	"errors"
 	"fmt"
+	"unsafe"
 )
 
 type (
@@ -25,8 +27,10 @@ type (
 
 const (
 	NoCodec VideoCodec = C.AV_CODEC_ID_NONE
+	VP8     VideoCodec = C.AV_CODEC_ID_VP8
 	VP9     VideoCodec = C.AV_CODEC_ID_VP9
 	H264    VideoCodec = C.AV_CODEC_ID_H264
+	AV1     VideoCodec = C.AV_CODEC_ID_AV1
 )
 
 const (
@@ -51,6 +55,18 @@ func NewCodec(codec_type VideoCodec) (*Codec, error) {
 	return codec, nil
 }
 
+// NewCodecByName picks a specific encoder when several implement the same codec, e.g: "libsvtav1"
+func NewCodecByName(name string) (*Codec, error) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+
+	codec := C.avcodec_find_encoder_by_name(cName)
+	if codec == nil {
+		return nil, fmt.Errorf("encoder %s not found", name)
+	}
+	return codec, nil
+}
+
 func NewCodecCtx(codec *Codec) (*CodecCtx, error) {
 	codecCtx := C.avcodec_alloc_context3(codec)
 	if codecCtx == nil {
@@ -99,6 +115,28 @@ func SetBitrate(bitrate int) CodecCtxOption {
 	}
 }
 
+// SetMaxRate and SetBufferSize enable the rate control buffer (VBV),
+// which has to be enabled when the context is opened for UpdateBitrate to work
+func SetMaxRate(maxRate int) CodecCtxOption {
+	return func(c *CodecCtx) {
+		c.rc_max_rate = C.int64_t(maxRate)
+	}
+}
+
+func SetBufferSize(bufferSize int) CodecCtxOption {
+	return func(c *CodecCtx) {
+		c.rc_buffer_size = C.int(bufferSize)
+	}
+}
+
+// UpdateBitrate changes the bitrate of an opened context,
+// encoders able to reconfigure themselves (e.g: libx264) apply it on the next frame
+func (c *CodecCtx) UpdateBitrate(bitrate, bufferSize int) {
+	c.bit_rate = C.int64_t(bitrate)
+	c.rc_max_rate = C.int64_t(bitrate)
+	c.rc_buffer_size = C.int(bufferSize)
+}
+
 func SetPixelFormat(pixFmt int) CodecCtxOption {
 	return func(c *CodecCtx) {
 		c.pix_fmt = int32(pixFmt)
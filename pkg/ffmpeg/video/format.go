@@ -14,4 +14,9 @@ const (
 	RGB    PixelFormat = C.AV_PIX_FMT_RGB24
 	RGBA   PixelFormat = C.AV_PIX_FMT_RGBA
 	YUV420 PixelFormat = C.AV_PIX_FMT_YUV420P
+
+	// packed formats of libretro cores, as laid out in memory on little-endian hosts
+	RGB565LE PixelFormat = C.AV_PIX_FMT_RGB565LE // RETRO_PIXEL_FORMAT_RGB565
+	RGB555LE PixelFormat = C.AV_PIX_FMT_RGB555LE // RETRO_PIXEL_FORMAT_0RGB1555, top bit unused
+	BGR0     PixelFormat = C.AV_PIX_FMT_BGR0     // RETRO_PIXEL_FORMAT_XRGB8888, bytes B G R X
 )
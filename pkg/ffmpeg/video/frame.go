@@ -4,6 +4,7 @@ package video
 #cgo pkg-config: libavutil
 #include <libavutil/frame.h>
 #include <libavutil/imgutils.h>
+#include <libavutil/pixfmt.h>
 */
 import "C"
 import (
@@ -13,6 +14,13 @@ import (
 
 type (
 	AVFrame = C.AVFrame
+
+	PictureType int
+)
+
+const (
+	PictureTypeNone PictureType = C.AV_PICTURE_TYPE_NONE // let the encoder decide
+	PictureTypeI    PictureType = C.AV_PICTURE_TYPE_I
 )
 
 func NewFrame() *AVFrame {
@@ -47,17 +55,41 @@ func NewFrameWithBufferAsArray(width, height int, format PixelFormat, data []byt
 	frame.SetHeight(height)
 	frame.SetFormat(int(format))
 
-	frameData := (**C.uchar)(frame.GetData())
-	frameLinesize := (*C.int)(frame.GetLinesize())
+	if err := frame.FillArrays(data); err != nil {
+		frame.Close()
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// FillArrays points the planes of the frame to data, according to its size and format.
+// The frame does not own data, so it can be re-pointed on every call without allocating.
+func (f *AVFrame) FillArrays(data []byte) error {
+	frameData := (**C.uchar)(f.GetData())
+	frameLinesize := (*C.int)(f.GetLinesize())
 
 	if ret := C.av_image_fill_arrays(
 		frameData, frameLinesize, (*C.uint8_t)(unsafe.Pointer(&data[0])),
-		C.AV_PIX_FMT_RGB24, C.int(width), C.int(height), 1); ret < 0 {
-		frame.Close()
-		return nil, errors.New("attach buffer to frame failed")
+		int32(f.format), f.width, f.height, 1); ret < 0 {
+		return errors.New("attach buffer to frame failed")
 	}
 
-	return frame, nil
+	return nil
+}
+
+// MakeWritable makes sure the buffer of the frame is not shared, e.g: with an encoder that still holds
+// the previous frame, a shared buffer is copied before being written to
+func (f *AVFrame) MakeWritable() error {
+	if ret := C.av_frame_make_writable(f); ret < 0 {
+		return errors.New("make frame writable failed")
+	}
+	return nil
+}
+
+// Plane returns the pixels of the plane i and its line size
+func (f *AVFrame) Plane(i int) (unsafe.Pointer, int) {
+	return unsafe.Pointer(f.data[i]), int(f.linesize[i])
 }
 
 func (f *AVFrame) GetWidth() int {
@@ -101,6 +133,19 @@ func (f *AVFrame) SetLinesize(linesize [8]int) {
 	f.linesize = arr
 }
 
+// FillBlack fills the whole frame with black, in limited range for yuv formats
+func (f *AVFrame) FillBlack() error {
+	var linesize [4]C.ptrdiff_t
+	for i := range linesize {
+		linesize[i] = C.ptrdiff_t(f.linesize[i])
+	}
+
+	if ret := C.av_image_fill_black(&f.data[0], &linesize[0], int32(f.format), C.AVCOL_RANGE_MPEG, f.width, f.height); ret < 0 {
+		return errors.New("fill black failed")
+	}
+	return nil
+}
+
 func (f *AVFrame) GetPTS() int64 {
 	return int64(f.pts)
 }
@@ -109,6 +154,11 @@ func (f *AVFrame) SetPTS(pts int64) {
 	f.pts = C.long(pts)
 }
 
+// SetPictureType forces the type of the encoded frame, PictureTypeI requests a keyframe
+func (f *AVFrame) SetPictureType(pictType PictureType) {
+	f.pict_type = uint32(pictType)
+}
+
 func (f *AVFrame) Close() {
 	C.av_frame_free(&f)
 }
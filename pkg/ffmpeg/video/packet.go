@@ -26,3 +26,7 @@ func (p *Packet) GetData() unsafe.Pointer {
 func (p *Packet) GetSize() int {
 	return int(p.size)
 }
+
+func (p *Packet) GetPTS() int64 {
+	return int64(p.pts)
+}
@@ -2,7 +2,41 @@ package video
 
 /*
 #cgo pkg-config: libswscale
+#cgo pkg-config: libavutil
 #include <libswscale/swscale.h>
+#include <libavutil/frame.h>
+#include <libavutil/pixdesc.h>
+
+// offset_planes points out to the pixel (x, y) of every plane of f
+static int offset_planes(AVFrame *f, int x, int y, uint8_t *out[4]) {
+	const AVPixFmtDescriptor *desc = av_pix_fmt_desc_get(f->format);
+	if (!desc) {
+		return -1;
+	}
+
+	for (int i = 0; i < 4; i++) {
+		out[i] = f->data[i];
+		if (!out[i]) {
+			continue;
+		}
+
+		int step = 0;
+		for (int c = 0; c < desc->nb_components; c++) {
+			if (desc->comp[c].plane == i) {
+				step = desc->comp[c].step;
+				break;
+			}
+		}
+
+		int px = x, py = y;
+		if (i == 1 || i == 2) {
+			px >>= desc->log2_chroma_w;
+			py >>= desc->log2_chroma_h;
+		}
+		out[i] += py * f->linesize[i] + px * step;
+	}
+	return 0;
+}
 */
 import "C"
 import (
@@ -17,6 +51,7 @@ type (
 
 const (
 	SWS_BILINEAR = C.SWS_BILINEAR
+	SWS_POINT    = C.SWS_POINT // nearest neighbor, for integer scaling
 )
 
 func NewSwsCtx(from_width, from_height, to_width, to_height int, from_format, to_format PixelFormat, scalingAlgo int) *SwsContext {
@@ -58,3 +93,34 @@ func ScaleAndConvertFrame(swsCtx *SwsContext, srcFrame *AVFrame, targetWidth, ta
 
 	return desFrame, nil
 }
+
+// ScaleAndConvertFrameInto scales srcFrame into the rectangle (x, y, width, height) of desFrame,
+// the rest of desFrame is left untouched. x and y should be even for subsampled formats.
+func ScaleAndConvertFrameInto(swsCtx *SwsContext, srcFrame, desFrame *AVFrame, x, y, width, height int) error {
+	if swsCtx == nil {
+		return errors.New("ScaleAndConvertFrameInto: sws context is nil")
+	}
+
+	if srcFrame == nil || desFrame == nil {
+		return errors.New("ScaleAndConvertFrameInto: frame is nil")
+	}
+
+	if x < 0 || y < 0 || x+width > desFrame.GetWidth() || y+height > desFrame.GetHeight() {
+		return errors.New("ScaleAndConvertFrameInto: rectangle out of frame")
+	}
+
+	var desData [4]*C.uint8_t
+	if ret := C.offset_planes(desFrame, C.int(x), C.int(y), &desData[0]); ret < 0 {
+		return errors.New("ScaleAndConvertFrameInto: unknown pixel format")
+	}
+
+	srcData := (**C.uchar)(srcFrame.GetData())
+	srcLinesize := (*C.int)(srcFrame.GetLinesize())
+	desLinesize := (*C.int)(desFrame.GetLinesize())
+
+	if ret := C.sws_scale(swsCtx, srcData, srcLinesize, 0, C.int(srcFrame.GetHeight()), &desData[0], desLinesize); ret != C.int(height) {
+		return fmt.Errorf("ScaleAndConvertFrameInto: num of rows copied is not equal to height")
+	}
+
+	return nil
+}
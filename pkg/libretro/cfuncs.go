@@ -149,6 +149,10 @@ void bridge_retro_audio_set_state(retro_audio_set_state_callback_t f, bool state
 	f(state);
 }
 
+void bridge_retro_keyboard_callback(retro_keyboard_event_t f, bool down, unsigned keycode, uint32_t character, uint16_t key_modifiers) {
+	f(down, keycode, character, key_modifiers);
+}
+
 void bridge_retro_get_system_info(void *f, struct retro_system_info *si) {
   return ((void (*)(struct retro_system_info *))f)(si);
 }
@@ -314,5 +318,10 @@ int64_t coreGetTimeUsec_cgo() {
 	return coreGetTimeUsec();
 }
 
+bool coreSetRumbleState_cgo(unsigned port, enum retro_rumble_effect effect, uint16_t strength) {
+	bool coreSetRumbleState(unsigned, enum retro_rumble_effect, uint16_t);
+	return coreSetRumbleState(port, effect, strength);
+}
+
 */
 import "C"
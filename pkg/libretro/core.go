@@ -33,6 +33,7 @@ type Core struct {
 	AudioCallback       *AudioCallback
 	FrameTimeCallback   *FrameTimeCallback
 	DiskControlCallback *DiskControlCallback
+	KeyboardCallback    *KeyboardCallback
 
 	MemoryMap []MemoryDescriptor
 }
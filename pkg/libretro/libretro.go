@@ -39,6 +39,7 @@ void bridge_retro_reset(void *f);
 void bridge_retro_frame_time_callback(retro_frame_time_callback_t f, retro_usec_t usec);
 void bridge_retro_audio_callback(retro_audio_callback_t f);
 void bridge_retro_audio_set_state(retro_audio_set_state_callback_t f, bool state);
+void bridge_retro_keyboard_callback(retro_keyboard_event_t f, bool down, unsigned keycode, uint32_t character, uint16_t key_modifiers);
 size_t bridge_retro_get_memory_size(void *f, unsigned id);
 void* bridge_retro_get_memory_data(void *f, unsigned id);
 void bridge_retro_set_eject_state(retro_set_eject_state_t f, bool state);
@@ -55,6 +56,7 @@ size_t coreAudioSampleBatch_cgo(const int16_t *data, size_t frames);
 int16_t coreInputState_cgo(unsigned port, unsigned device, unsigned index, unsigned id);
 void coreLog_cgo(enum retro_log_level level, const char *msg);
 int64_t coreGetTimeUsec_cgo();
+bool coreSetRumbleState_cgo(unsigned port, enum retro_rumble_effect effect, uint16_t strength);
 */
 import "C"
 import (
@@ -67,12 +69,27 @@ const (
 	KEYBOARD = C.RETRO_DEVICE_KEYBOARD
 	JOYPAD   = C.RETRO_DEVICE_JOYPAD
 	MOUSE    = C.RETRO_DEVICE_MOUSE
+	ANALOG   = C.RETRO_DEVICE_ANALOG
+	LIGHTGUN = C.RETRO_DEVICE_LIGHTGUN
+	POINTER  = C.RETRO_DEVICE_POINTER
 )
 
 const (
 	NO_KB_KEYS = C.RETROK_LAST //total number of keys
 )
 
+// Keyboard modifiers, or'ed together in the keyboard callback
+const (
+	KeyModNone       = uint16(C.RETROKMOD_NONE)
+	KeyModShift      = uint16(C.RETROKMOD_SHIFT)
+	KeyModCtrl       = uint16(C.RETROKMOD_CTRL)
+	KeyModAlt        = uint16(C.RETROKMOD_ALT)
+	KeyModMeta       = uint16(C.RETROKMOD_META)
+	KeyModNumLock    = uint16(C.RETROKMOD_NUMLOCK)
+	KeyModCapsLock   = uint16(C.RETROKMOD_CAPSLOCK)
+	KeyModScrollLock = uint16(C.RETROKMOD_SCROLLOCK)
+)
+
 const (
 	ENVIRONMENT_SET_PIXEL_FORMAT = C.RETRO_ENVIRONMENT_SET_PIXEL_FORMAT
 	ENVIRONMENT_SET_ROTATION     = C.RETRO_ENVIRONMENT_SET_ROTATION
@@ -87,6 +104,15 @@ type GameGeometry struct {
 	MaxHeight   int
 }
 
+// Framebuffer is a buffer of the frontend the core renders into, see EnvironmentGetCurrentSoftwareFramebuffer
+type Framebuffer struct {
+	Data   unsafe.Pointer
+	Width  int
+	Height int
+	Pitch  int
+	Format uint32
+}
+
 // GameInfo stores information about a ROM
 type GameInfo struct {
 	Path string
@@ -127,6 +153,21 @@ type MemoryDescriptor struct {
 	Addrspace  string
 }
 
+// ControllerDescription is a device type a controller port supports, e.g: "Multitap"
+type ControllerDescription struct {
+	Desc string
+	ID   uint32
+}
+
+// InputDescriptor associates a human readable description with a port, device, index and id
+type InputDescriptor struct {
+	Port        uint
+	Device      uint32
+	Index       uint
+	ID          uint
+	Description string
+}
+
 // Variable is a key value pair that represents a core option
 type Variable C.struct_retro_variable
 
@@ -235,6 +276,11 @@ type FrameTimeCallback struct {
 	Reference int64
 }
 
+// KeyboardCallback stores the callback used to notify the core about keyboard events
+type KeyboardCallback struct {
+	Callback func(down bool, keycode uint, character uint32, modifiers uint16)
+}
+
 // AudioCallback stores the audio callback itself and the SetState callback
 type AudioCallback struct {
 	Callback func()
@@ -298,6 +344,11 @@ const (
 	DeviceAnalog = uint32(C.RETRO_DEVICE_ANALOG)
 )
 
+// DeviceMask extracts the base device type of a subclassed device
+const (
+	DeviceMask = uint32(C.RETRO_DEVICE_MASK)
+)
+
 // Buttons for the RetroPad (JOYPAD).
 // The placement of these is equivalent to placements on the
 // Super Nintendo controller.
@@ -350,6 +401,30 @@ const (
 	DeviceIDMouseButton5        = uint32(C.RETRO_DEVICE_ID_MOUSE_BUTTON_5)
 )
 
+// ID values for the lightgun device
+const (
+	DeviceIDLightgunScreenX     = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_SCREEN_X)
+	DeviceIDLightgunScreenY     = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_SCREEN_Y)
+	DeviceIDLightgunIsOffscreen = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_IS_OFFSCREEN)
+	DeviceIDLightgunTrigger     = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_TRIGGER)
+	DeviceIDLightgunReload      = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_RELOAD)
+	DeviceIDLightgunAuxA        = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_AUX_A)
+	DeviceIDLightgunAuxB        = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_AUX_B)
+	DeviceIDLightgunStart       = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_START)
+	DeviceIDLightgunSelect      = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_SELECT)
+	DeviceIDLightgunAuxC        = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_AUX_C)
+	DeviceIDLightgunX           = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_X) // Deprecated, relative position
+	DeviceIDLightgunY           = uint32(C.RETRO_DEVICE_ID_LIGHTGUN_Y) // Deprecated, relative position
+)
+
+// ID values for the pointer device
+const (
+	DeviceIDPointerX       = uint32(C.RETRO_DEVICE_ID_POINTER_X)
+	DeviceIDPointerY       = uint32(C.RETRO_DEVICE_ID_POINTER_Y)
+	DeviceIDPointerPressed = uint32(C.RETRO_DEVICE_ID_POINTER_PRESSED)
+	DeviceIDPointerCount   = uint32(C.RETRO_DEVICE_ID_POINTER_COUNT)
+)
+
 // Environment callback API. See libretro.h for details
 const (
 	EnvironmentSetRotation                      = uint32(C.RETRO_ENVIRONMENT_SET_ROTATION)
@@ -410,6 +485,12 @@ const (
 	EnvironmentGetDiskControlExtInterface       = uint32(C.RETRO_ENVIRONMENT_SET_DISK_CONTROL_EXT_INTERFACE)
 )
 
+// Rumble effects
+const (
+	RumbleStrong = uint32(C.RETRO_RUMBLE_STRONG)
+	RumbleWeak   = uint32(C.RETRO_RUMBLE_WEAK)
+)
+
 // Debug levels
 const (
 	LogLevelDebug = uint32(C.RETRO_LOG_DEBUG)
@@ -437,6 +518,7 @@ type (
 	inputStateFunc       func(uint, uint32, uint, uint) int16
 	logFunc              func(uint32, string)
 	getTimeUsecFunc      func() int64
+	RumbleFunc           func(port uint, effect uint32, strength uint16) bool
 )
 
 var (
@@ -448,6 +530,7 @@ var (
 	inputState       inputStateFunc
 	log              logFunc
 	getTimeUsec      getTimeUsecFunc
+	setRumbleState   RumbleFunc
 )
 
 // Load dynamically loads a libretro core at the given path and returns a Core instance
@@ -511,6 +594,7 @@ func (core *Core) Deinit() {
 	inputState = nil
 	log = nil
 	getTimeUsec = nil
+	setRumbleState = nil
 }
 
 // Run runs the game for one video frame.
@@ -674,6 +758,13 @@ func (core *Core) BindPerfCallback(data unsafe.Pointer, f getTimeUsecFunc) {
 	cb.get_time_usec = (C.retro_perf_get_time_usec_t)(C.coreGetTimeUsec_cgo)
 }
 
+// BindRumbleInterface binds f to the rumble interface set_rumble_state
+func (core *Core) BindRumbleInterface(data unsafe.Pointer, f RumbleFunc) {
+	setRumbleState = f
+	ri := (*C.struct_retro_rumble_interface)(data)
+	ri.set_rumble_state = (C.retro_set_rumble_state_t)(C.coreSetRumbleState_cgo)
+}
+
 // SetControllerPortDevice sets the device type attached to a controller port
 func (core *Core) SetControllerPortDevice(port uint, device uint32) {
 	C.bridge_retro_set_controller_port_device(core.symRetroSetControllerPortDevice, C.unsigned(port), C.unsigned(device))
@@ -743,6 +834,14 @@ func coreGetTimeUsec() C.uint64_t {
 	return C.uint64_t(getTimeUsec())
 }
 
+//export coreSetRumbleState
+func coreSetRumbleState(port C.unsigned, effect C.enum_retro_rumble_effect, strength C.uint16_t) C.bool {
+	if setRumbleState == nil {
+		return false
+	}
+	return C.bool(setRumbleState(uint(port), uint32(effect), uint16(strength)))
+}
+
 // SetData is a setter for the data of a GameInfo type
 func (gi *GameInfo) SetData(bytes []byte) {
 	cstr := C.CString(string(bytes))
@@ -844,6 +943,56 @@ func GetMemoryMap(data unsafe.Pointer) []MemoryDescriptor {
 	return descriptors
 }
 
+// GetInputDescriptors is an environment callback helper that returns the list of
+// InputDescriptor announced by a core in EnvironmentSetInputDescriptors.
+func GetInputDescriptors(data unsafe.Pointer) []InputDescriptor {
+	var descriptors []InputDescriptor
+
+	for {
+		d := (*C.struct_retro_input_descriptor)(data)
+		if d.description == nil {
+			break
+		}
+		descriptors = append(descriptors, InputDescriptor{
+			Port:        uint(d.port),
+			Device:      uint32(d.device),
+			Index:       uint(d.index),
+			ID:          uint(d.id),
+			Description: C.GoString(d.description),
+		})
+		data = unsafe.Pointer(uintptr(data) + unsafe.Sizeof(*d))
+	}
+
+	return descriptors
+}
+
+// GetControllerInfo is an environment callback helper that returns, for each port of the
+// emulated device, the device types it supports in EnvironmentSetControllerInfo.
+func GetControllerInfo(data unsafe.Pointer) [][]ControllerDescription {
+	var ports [][]ControllerDescription
+
+	for {
+		info := (*C.struct_retro_controller_info)(data)
+		if info.types == nil {
+			break
+		}
+
+		types := unsafe.Slice(info.types, int(info.num_types))
+		devices := make([]ControllerDescription, 0, len(types))
+		for _, t := range types {
+			devices = append(devices, ControllerDescription{
+				Desc: C.GoString(t.desc),
+				ID:   uint32(t.id),
+			})
+		}
+
+		ports = append(ports, devices)
+		data = unsafe.Pointer(uintptr(data) + unsafe.Sizeof(*info))
+	}
+
+	return ports
+}
+
 // GetGeometry is an environment callback helper that returns the game geometry
 // in EnvironmentSetGeometry.
 func GetGeometry(data unsafe.Pointer) GameGeometry {
@@ -857,8 +1006,8 @@ func GetGeometry(data unsafe.Pointer) GameGeometry {
 	}
 }
 
-// GetSystemAVInfo is an environment callback helper that returns the game geometry
-// in EnvironmentSetGeometry.
+// GetSystemAVInfo is an environment callback helper that returns the system av info
+// in EnvironmentSetSystemAVInfo.
 func GetSystemAVInfo(data unsafe.Pointer) SystemAVInfo {
 	avi := (*C.struct_retro_system_av_info)(data)
 	return SystemAVInfo{
@@ -888,6 +1037,25 @@ func SetString(data unsafe.Pointer, val string) {
 	*s = C.CString(val)
 }
 
+// GetFramebufferSize is an environment callback helper that returns the size of the framebuffer
+// requested in EnvironmentGetCurrentSoftwareFramebuffer
+func GetFramebufferSize(data unsafe.Pointer) (int, int) {
+	fb := (*C.struct_retro_framebuffer)(data)
+	return int(fb.width), int(fb.height)
+}
+
+// SetFramebuffer is an environment callback helper that hands a buffer of the frontend to the core
+// in EnvironmentGetCurrentSoftwareFramebuffer
+func SetFramebuffer(data unsafe.Pointer, framebuffer Framebuffer) {
+	fb := (*C.struct_retro_framebuffer)(data)
+	fb.data = framebuffer.Data
+	fb.width = C.uint(framebuffer.Width)
+	fb.height = C.uint(framebuffer.Height)
+	fb.pitch = C.size_t(framebuffer.Pitch)
+	fb.format = C.enum_retro_pixel_format(framebuffer.Format)
+	fb.memory_flags = C.RETRO_MEMORY_TYPE_CACHED
+}
+
 // SetUint is an environment callback helper to set a string
 func SetUint(data unsafe.Pointer, val uint) {
 	i := (*C.uint)(data)
@@ -905,6 +1073,16 @@ func (core *Core) SetFrameTimeCallback(data unsafe.Pointer) {
 	core.FrameTimeCallback = ftc
 }
 
+// SetKeyboardCallback is an environment callback helper to set the KeyboardCallback
+func (core *Core) SetKeyboardCallback(data unsafe.Pointer) {
+	c := *(*C.struct_retro_keyboard_callback)(data)
+	kbc := &KeyboardCallback{}
+	kbc.Callback = func(down bool, keycode uint, character uint32, modifiers uint16) {
+		C.bridge_retro_keyboard_callback(c.callback, C.bool(down), C.unsigned(keycode), C.uint32_t(character), C.uint16_t(modifiers))
+	}
+	core.KeyboardCallback = kbc
+}
+
 // SetAudioCallback is an environment callback helper to set the AudioCallback
 func (core *Core) SetAudioCallback(data unsafe.Pointer) {
 	c := *(*C.struct_retro_audio_callback)(data)
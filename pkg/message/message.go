@@ -6,12 +6,20 @@ type (
 	RequestMsg struct {
 		Label   MsgType `json:"label"`
 		Payload []byte  `json:"payload"`
+		// PeerID is set by the coordinator, it identifies the user connection the request comes from
+		PeerID string `json:"peer_id,omitempty"`
+		// User is the identity of the user connection, set by the coordinator
+		User string `json:"user,omitempty"`
+		// Owner is set by the coordinator when the request comes from the user that started the session
+		Owner bool `json:"owner,omitempty"`
 	}
 
 	ResponseMsg struct {
 		Label   MsgType `json:"label"`
 		Payload []byte  `json:"payload"`
 		Error   error   `json:"error"`
+		// PeerID sends the response to a single user of the worker, every user gets it when empty
+		PeerID string `json:"peer_id,omitempty"`
 	}
 
 	MsgType string
@@ -19,6 +27,10 @@ type (
 
 const (
 	MSG_COOR_HANDSHAKE MsgType = "msg_coor_handshake"
+	// MSG_SESSION gives a user the id other users join its session with, e.g: /init/user/ws?session=<id>
+	MSG_SESSION MsgType = "msg_session"
+	// MSG_PEER_LEFT tells the worker that the user PeerID disconnected
+	MSG_PEER_LEFT MsgType = "msg_peer_left"
 )
 
 const (
@@ -33,6 +45,49 @@ const (
 	MSG_STOP_GAME  MsgType = "msg_stop_game"
 )
 
+const (
+	MSG_INPUT_DESCRIPTORS MsgType = "msg_input_descriptors"
+)
+
+const (
+	MSG_GET_INPUT_PROFILE    MsgType = "msg_get_input_profile"
+	MSG_UPDATE_INPUT_PROFILE MsgType = "msg_update_input_profile"
+	MSG_INPUT_PROFILE        MsgType = "msg_input_profile"
+)
+
+const (
+	MSG_CONTROLLER_INFO       MsgType = "msg_controller_info"
+	MSG_SET_CONTROLLER_DEVICE MsgType = "msg_set_controller_device"
+	MSG_ASSIGN_PORT           MsgType = "msg_assign_port"
+	MSG_RELEASE_PORT          MsgType = "msg_release_port"
+	MSG_PORT_ASSIGNED         MsgType = "msg_port_assigned"
+)
+
+const (
+	MSG_START_RECORDING MsgType = "msg_start_recording"
+	MSG_STOP_RECORDING  MsgType = "msg_stop_recording"
+	MSG_START_PLAYBACK  MsgType = "msg_start_playback"
+	MSG_STOP_PLAYBACK   MsgType = "msg_stop_playback"
+	MSG_MOVIE_STATUS    MsgType = "msg_movie_status"
+)
+
+const (
+	MSG_VIDEO_GEOMETRY MsgType = "msg_video_geometry"
+	MSG_VIDEO_ENCODER  MsgType = "msg_video_encoder"
+	MSG_SET_QUALITY    MsgType = "msg_set_quality"
+	MSG_QUALITY        MsgType = "msg_quality"
+)
+
+const (
+	MSG_GET_FRAME_STATS MsgType = "msg_get_frame_stats"
+	MSG_FRAME_STATS     MsgType = "msg_frame_stats"
+)
+
+const (
+	MSG_GET_AV_SYNC MsgType = "msg_get_av_sync"
+	MSG_AV_SYNC     MsgType = "msg_av_sync"
+)
+
 func NewErrorMsg(label MsgType, text string) *ResponseMsg {
 	return &ResponseMsg{
 		Label: label,
@@ -5,6 +5,8 @@ import (
 	"cloud_gaming/pkg/ffmpeg/audio"
 	"cloud_gaming/pkg/libretro"
 	"cloud_gaming/pkg/log"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -15,8 +17,23 @@ type (
 		maxLen int
 		offset int
 
-		channel    int // channel is always 2 for libretro
-		sampleRate int
+		channel    int // channel is always 2 for libretro, the profile may encode mono
+		sampleRate int // of the encoded audio, always OUTPUT_SAMPLE_RATE
+
+		profile        Profile
+		pendingProfile atomic.Pointer[Profile]
+
+		// the core's audio is resampled to sampleRate
+		inputRate   float64
+		resampler   *resampler
+		rateControl rateControl
+		resampled   []int16
+
+		// samples are stamped from basePTS on, bufferPTS is the pts of the first sample in buffer
+		basePTS   time.Duration
+		samples   int64 // resampled frames since basePTS
+		bufferPTS time.Duration
+		synced    bool
 
 		sendAudioPacket SendAudioPacketFunc
 
@@ -28,42 +45,73 @@ type (
 		Format   audio.AudioFormat `json:"format"`
 		Codec    audio.AudioCodec  `json:"codec"`
 		Duration float64           `json:"duration"` // in milliseconds
+		PTS      time.Duration     `json:"pts"`      // on the session timeline, shared with video
 	}
 
 	SendAudioPacketFunc func(*AudioPacket)
 )
 
+const (
+	// audio further than this from the frames producing it is stamped again from the frame's pts
+	MAX_AUDIO_PTS_DRIFT = 100 * time.Millisecond
+)
+
 func NewAudioPipeline(sendAudioPacket SendAudioPacketFunc) *AudioPipeline {
 	return &AudioPipeline{
 		offset:          0,
 		channel:         2,
+		profile:         DefaultProfile(),
 		sendAudioPacket: sendAudioPacket,
 	}
 }
 
 func (a *AudioPipeline) createEncoder() error {
 	var err error
-	if a.enc, err = encoder.NewOpusEncoder(a.sampleRate, a.channel); err != nil {
+	if a.enc, err = encoder.NewOpusEncoder(encoder.OpusOptions{
+		SampleRate:    a.sampleRate,
+		Channels:      a.profile.Channels,
+		Application:   a.profile.Application,
+		Bitrate:       a.profile.Bitrate,
+		FrameDuration: a.profile.FrameDuration,
+		Complexity:    a.profile.Complexity,
+		PacketLoss:    a.profile.PacketLoss,
+		MaxBandwidth:  a.profile.MaxBandwidth,
+		FEC:           a.profile.FEC,
+		DTX:           a.profile.DTX,
+	}); err != nil {
 		return err
 	}
 	return nil
 }
 
 func (a *AudioPipeline) SetSystemAudioInfo(systemAVInfo *libretro.SystemAVInfo) {
-	sampleRate := systemAVInfo.Timing.SampleRate
-	if sampleRate != 48000 {
-		sampleRate = 48000
+	a.applyPendingProfile()
+
+	inputRate := systemAVInfo.Timing.SampleRate
+	// keep the pending samples when the core only changes its video timing mid-game
+	if inputRate == a.inputRate && a.resampler != nil {
+		return
 	}
+	a.inputRate = inputRate
+	a.resampler = newResampler(int(inputRate), OUTPUT_SAMPLE_RATE, a.channel)
+	a.rateControl = rateControl{sampleRate: OUTPUT_SAMPLE_RATE}
 
-	maxLen := int16(sampleRate * 10 / 1000 * float64(a.channel))
-	buffer := make([]int16, maxLen)
+	a.sampleRate = OUTPUT_SAMPLE_RATE
+	a.allocateBuffer()
+}
 
-	a.buffer = buffer
-	a.maxLen = int(maxLen)
-	a.sampleRate = int(sampleRate)
+// PacketDuration returns the duration of audio carried by each packet
+func (a *AudioPipeline) PacketDuration() time.Duration {
+	if a.sampleRate == 0 {
+		return 0
+	}
+	return a.profile.FrameDuration
 }
 
-func (a *AudioPipeline) Process(data []int16, frames int32) {
+// Process encodes the audio of the core, pts is the time the emulated frame producing it started
+func (a *AudioPipeline) Process(data []int16, frames int32, pts time.Duration) {
+	a.applyPendingProfile()
+
 	if a.enc == nil {
 		if err := a.createEncoder(); err != nil {
 			log.Error("encoder is nil", zap.Error(err))
@@ -71,6 +119,18 @@ func (a *AudioPipeline) Process(data []int16, frames int32) {
 		}
 	}
 
+	if a.resampler == nil {
+		return
+	}
+
+	a.resampled = a.resampler.Process(data, a.resampled[:0])
+	data = a.resampled
+	a.resampler.SetAdjustment(a.rateControl.Update(len(data) / a.channel))
+	a.syncPTS(pts)
+	if a.profile.Channels == 1 {
+		data = downmix(data)
+	}
+
 	dataOffset := 0
 	for dataOffset < len(data) {
 		writtenLen := min(a.maxLen-a.offset, len(data)-dataOffset)
@@ -88,14 +148,35 @@ func (a *AudioPipeline) Process(data []int16, frames int32) {
 				Buffer:   buf,
 				Format:   audio.PCM,
 				Codec:    audio.OPUS,
-				Duration: 10,
+				Duration: float64(a.profile.FrameDuration) / float64(time.Millisecond),
+				PTS:      a.bufferPTS,
 			})
 		}
 
 	}
 }
 
+// syncPTS follows the samples on the session timeline. Samples run continuously from the first frame,
+// they are moved back to the frame's pts only when they drifted away, e.g: the core skipped audio.
+func (a *AudioPipeline) syncPTS(framePTS time.Duration) {
+	if drift := a.samplePTS() - framePTS; !a.synced || drift > MAX_AUDIO_PTS_DRIFT || drift < -MAX_AUDIO_PTS_DRIFT {
+		a.basePTS = framePTS
+		a.samples = 0
+		a.synced = true
+	}
+}
+
+// samplePTS returns the pts of the next sample
+func (a *AudioPipeline) samplePTS() time.Duration {
+	return a.basePTS + time.Duration(a.samples)*time.Second/time.Duration(a.sampleRate)
+}
+
 func (a *AudioPipeline) write(data []int16, from int, length int) {
+	if a.offset == 0 {
+		a.bufferPTS = a.samplePTS()
+	}
+	a.samples += int64(length / a.profile.Channels)
+
 	for offset := 0; offset < length; offset++ {
 		a.buffer[a.offset+offset] = data[from+offset]
 	}
@@ -109,5 +190,12 @@ func (a *AudioPipeline) _sendAudioPacket(packet *AudioPacket) {
 }
 
 func (a *AudioPipeline) Close() error {
+	// the next game starts with an empty filter
+	a.resampler = nil
+	a.offset = 0
+	a.synced = false
+	if a.enc == nil {
+		return nil
+	}
 	return a.enc.Close()
 }
@@ -0,0 +1,78 @@
+package audio
+
+import (
+	"cloud_gaming/pkg/encoder"
+	"time"
+)
+
+type (
+	// Profile sets the encoded audio, mono is downmixed from the core's stereo
+	Profile struct {
+		Channels      int
+		Application   encoder.OpusApplication
+		Bitrate       int // bits per second
+		FrameDuration time.Duration
+		Complexity    int
+		PacketLoss    int // in percent
+		MaxBandwidth  encoder.OpusBandwidth
+		FEC           bool
+		DTX           bool
+	}
+)
+
+func DefaultProfile() Profile {
+	return Profile{
+		Channels:      2,
+		Application:   encoder.OpusAppLowDelay,
+		Bitrate:       96000,
+		FrameDuration: 10 * time.Millisecond,
+		Complexity:    10,
+		MaxBandwidth:  encoder.OpusFullband,
+		FEC:           true,
+		DTX:           true,
+	}
+}
+
+// SetProfile is applied before the next audio, or when the pipeline starts.
+// Audio waiting for a full packet is dropped.
+func (a *AudioPipeline) SetProfile(profile Profile) {
+	a.pendingProfile.Store(&profile)
+}
+
+// applyPendingProfile runs on the thread producing audio
+func (a *AudioPipeline) applyPendingProfile() {
+	profile := a.pendingProfile.Swap(nil)
+	if profile == nil || *profile == a.profile {
+		return
+	}
+
+	a.profile = *profile
+	if a.enc != nil {
+		a.enc.Close()
+		a.enc = nil
+	}
+	a.allocateBuffer()
+}
+
+// allocateBuffer sizes the buffer for one packet of the profile
+func (a *AudioPipeline) allocateBuffer() {
+	if a.sampleRate == 0 {
+		return
+	}
+
+	maxLen := int(time.Duration(a.sampleRate)*a.profile.FrameDuration/time.Second) * a.profile.Channels
+	if maxLen != a.maxLen {
+		a.buffer = make([]int16, maxLen)
+		a.maxLen = maxLen
+	}
+	a.offset = 0
+}
+
+// downmix averages the channels of the core's stereo frames in place
+func downmix(data []int16) []int16 {
+	mono := data[:len(data)/2]
+	for i := range mono {
+		mono[i] = int16((int32(data[2*i]) + int32(data[2*i+1])) / 2)
+	}
+	return mono
+}
@@ -0,0 +1,172 @@
+package audio
+
+import (
+	"math"
+	"time"
+)
+
+type (
+	// resampler converts interleaved int16 audio between sample rates with a windowed sinc filter,
+	// the ratio may be adjusted slightly at any time without clicks
+	resampler struct {
+		channels int
+		step     float64 // input frames per output frame
+		baseStep float64
+
+		// pending input frames, interleaved, starting with the oldest frame still in the filter
+		history []float32
+		// position of the next output frame in history, in input frames
+		pos float64
+
+		table [][]float32 // filter coefficients for each phase
+	}
+
+	// rateControl follows the drift between the audio produced by the core and the wall clock,
+	// e.g: the emulator runs at 60.1 fps while the core reports 60 fps
+	rateControl struct {
+		sampleRate int
+		start      time.Time
+		frames     int64 // output frames since start
+		adjustment float64
+	}
+)
+
+const (
+	OUTPUT_SAMPLE_RATE = 48000
+
+	// taps on each side of the output position, more taps mean a sharper filter and more latency
+	RESAMPLER_HALF_TAPS = 16
+	// filter phases between two input frames, interpolated linearly
+	RESAMPLER_PHASES = 256
+	// keeps the filter transition band under the Nyquist frequency
+	RESAMPLER_CUTOFF = 0.95
+
+	// the ratio is never adjusted by more than this, inaudible as a pitch change
+	MAX_RATE_ADJUSTMENT = 0.005
+	// time over which the drift is corrected
+	RATE_CONTROL_WINDOW = time.Second
+	// a larger drift is not corrected but forgotten, e.g: the game was paused
+	MAX_RATE_DRIFT = 200 * time.Millisecond
+)
+
+func newResampler(inRate, outRate, channels int) *resampler {
+	r := &resampler{
+		channels: channels,
+		step:     float64(inRate) / float64(outRate),
+		baseStep: float64(inRate) / float64(outRate),
+	}
+
+	// downsampling lowers the cutoff to avoid aliasing
+	cutoff := RESAMPLER_CUTOFF * min(1, float64(outRate)/float64(inRate))
+
+	r.table = make([][]float32, RESAMPLER_PHASES+1)
+	for p := range r.table {
+		frac := float64(p) / RESAMPLER_PHASES
+		taps := make([]float32, 2*RESAMPLER_HALF_TAPS)
+
+		var sum float64
+		coeffs := make([]float64, len(taps))
+		for k := range coeffs {
+			x := float64(k-RESAMPLER_HALF_TAPS+1) - frac
+			coeffs[k] = cutoff * sinc(cutoff*x) * blackman(x/RESAMPLER_HALF_TAPS)
+			sum += coeffs[k]
+		}
+		// unity gain at DC for every phase
+		for k := range coeffs {
+			taps[k] = float32(coeffs[k] / sum)
+		}
+		r.table[p] = taps
+	}
+
+	// the first output frame needs input frames before it
+	r.history = make([]float32, (RESAMPLER_HALF_TAPS-1)*channels)
+	r.pos = RESAMPLER_HALF_TAPS - 1
+	return r
+}
+
+// SetAdjustment changes the ratio by a small factor, a positive adjustment produces fewer output frames
+func (r *resampler) SetAdjustment(adjustment float64) {
+	r.step = r.baseStep * (1 + adjustment)
+}
+
+// Process resamples in and appends the result to out
+func (r *resampler) Process(in []int16, out []int16) []int16 {
+	for _, s := range in {
+		r.history = append(r.history, float32(s))
+	}
+
+	frames := len(r.history) / r.channels
+	for int(r.pos)+RESAMPLER_HALF_TAPS < frames {
+		i := int(r.pos)
+		frac := (r.pos - float64(i)) * RESAMPLER_PHASES
+		phase := int(frac)
+		mix := float32(frac - float64(phase))
+		t0, t1 := r.table[phase], r.table[phase+1]
+
+		first := (i - RESAMPLER_HALF_TAPS + 1) * r.channels
+		for c := 0; c < r.channels; c++ {
+			var acc float32
+			for k := range t0 {
+				h := t0[k] + (t1[k]-t0[k])*mix
+				acc += r.history[first+k*r.channels+c] * h
+			}
+			out = append(out, clamp16(acc))
+		}
+
+		r.pos += r.step
+	}
+
+	// drop the frames no output frame needs anymore
+	if drop := int(r.pos) - RESAMPLER_HALF_TAPS + 1; drop > 0 {
+		n := copy(r.history, r.history[drop*r.channels:])
+		r.history = r.history[:n]
+		r.pos -= float64(drop)
+	}
+
+	return out
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// blackman is the window of the filter, t in [-1, 1]
+func blackman(t float64) float64 {
+	if t <= -1 || t >= 1 {
+		return 0
+	}
+	return 0.42 + 0.5*math.Cos(math.Pi*t) + 0.08*math.Cos(2*math.Pi*t)
+}
+
+func clamp16(v float32) int16 {
+	return int16(min(max(v, math.MinInt16), math.MaxInt16))
+}
+
+// Update counts the output frames and returns the ratio adjustment that brings the audio back to the wall clock
+func (rc *rateControl) Update(frames int) float64 {
+	now := time.Now()
+	if rc.start.IsZero() {
+		rc.start = now
+	}
+	rc.frames += int64(frames)
+
+	produced := time.Duration(rc.frames) * time.Second / time.Duration(rc.sampleRate)
+	drift := produced - now.Sub(rc.start)
+	if drift > MAX_RATE_DRIFT || drift < -MAX_RATE_DRIFT {
+		rc.Reset()
+		return 0
+	}
+
+	// ahead of the wall clock: consume more input per output frame
+	rc.adjustment = min(max(drift.Seconds()/RATE_CONTROL_WINDOW.Seconds(), -MAX_RATE_ADJUSTMENT), MAX_RATE_ADJUSTMENT)
+	return rc.adjustment
+}
+
+func (rc *rateControl) Reset() {
+	rc.start = time.Time{}
+	rc.frames = 0
+	rc.adjustment = 0
+}
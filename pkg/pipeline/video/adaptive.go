@@ -0,0 +1,104 @@
+package video
+
+import (
+	"cloud_gaming/pkg/encoder"
+	"cloud_gaming/pkg/log"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// degradation is applied while the bandwidth estimate is above minBitrate
+	degradation struct {
+		minBitrate int
+		scale      float64 // of the output resolution
+		fpsDivisor int     // only 1 frame out of fpsDivisor is encoded
+	}
+)
+
+// degradations from the best to the worst, the last one must have minBitrate 0
+var degradations = []degradation{
+	{minBitrate: 800000, scale: 1, fpsDivisor: 1},
+	{minBitrate: 400000, scale: 0.75, fpsDivisor: 1},
+	{minBitrate: 200000, scale: 0.5, fpsDivisor: 1},
+	{minBitrate: 0, scale: 0.5, fpsDivisor: 2},
+}
+
+const (
+	// the estimate has to exceed the threshold of a better degradation by this ratio to move up,
+	// so that an estimate around a threshold does not restart the encoder all the time
+	DEGRADATION_HYSTERESIS = 1.25
+
+	// encoders that cannot change their bitrate are only restarted for larger changes
+	MIN_RESTART_BITRATE_CHANGE = 0.25
+)
+
+// SetTargetBitrate is called by the congestion controller, it is applied before the next frame
+func (v *VideoPipeline) SetTargetBitrate(bitrate int) {
+	v.pendingBitrate.Store(int64(bitrate))
+}
+
+// applyTargetBitrate runs on the emulator thread, the bitrate reaches the encoder with the next frame
+func (v *VideoPipeline) applyTargetBitrate() {
+	estimate := int(v.pendingBitrate.Swap(0))
+	if estimate <= 0 {
+		return
+	}
+	v.estimate = estimate
+
+	if level := v.chooseDegradation(estimate); level != v.level {
+		log.Info("video degradation changed", zap.Int("estimate", estimate), zap.Int("level", level))
+		v.level = level
+		v.updateOutputSize()
+		v.onOutputChanged()
+	}
+}
+
+// applyBitrate runs on the encoding goroutine, encoders that cannot change their bitrate
+// are restarted for larger changes only
+func (v *VideoPipeline) applyBitrate(s *encodingState, config encoderConfig) {
+	bitrate := config.bitrate
+	err := s.enc.SetBitrate(bitrate)
+	if errors.Is(err, encoder.ErrNotSupported) {
+		if change := float64(bitrate-s.config.bitrate) / float64(s.config.bitrate); change > MIN_RESTART_BITRATE_CHANGE || change < -MIN_RESTART_BITRATE_CHANGE {
+			v.restartEncoder(s, config)
+		}
+		return
+	}
+	if err != nil {
+		log.Error("set bitrate failed", zap.Error(err))
+		return
+	}
+	s.config.bitrate = bitrate
+}
+
+func (v *VideoPipeline) chooseDegradation(estimate int) int {
+	level := v.level
+	for level > 0 && float64(estimate) >= float64(degradations[level-1].minBitrate)*DEGRADATION_HYSTERESIS {
+		level--
+	}
+	for level < len(degradations)-1 && estimate < degradations[level].minBitrate {
+		level++
+	}
+	return level
+}
+
+// encoderBitrate is the bitrate of the quality preset, lowered to the bandwidth estimate
+func (v *VideoPipeline) encoderBitrate() int {
+	if v.estimate > 0 {
+		return min(v.quality.Bitrate, v.estimate)
+	}
+	return v.quality.Bitrate
+}
+
+func (v *VideoPipeline) degradation() degradation {
+	return degradations[v.level]
+}
+
+// skipFrame drops frames to lower the frame rate under the current degradation
+func (v *VideoPipeline) skipFrame() bool {
+	v.frameCount++
+	divisor := uint64(v.degradation().fpsDivisor)
+	return divisor > 1 && v.frameCount%divisor != 0
+}
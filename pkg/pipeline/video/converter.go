@@ -2,42 +2,154 @@ package video
 
 import (
 	"cloud_gaming/pkg/ffmpeg/video"
+	"sync"
 )
 
 type (
-	Converter struct{}
+	// Converter owns the frames it returns, converted frames go back to its pool with Release
+	// and everything is freed by Close. Only Release may be called from another goroutine than the emulator thread
+	Converter struct {
+		// src points to the core's pixels, it owns no buffer
+		src *video.AVFrame
+
+		// mu guards the pool of encoder inputs, rects maps each of them to the part
+		// the last frame was scaled into, the rest is black
+		mu    sync.Mutex
+		free  []*video.AVFrame
+		rects map[*video.AVFrame]Rect
+	}
 )
 
 func NewConverter() *Converter {
-	return &Converter{}
+	return &Converter{
+		rects: make(map[*video.AVFrame]Rect),
+	}
 }
 
-// RGB/RGBA to frame only
+// packed formats to frame only, e.g: the core's RGB565LE
 func (c *Converter) ToFrame(data []byte, width, height, pitch int, format video.PixelFormat) (*video.AVFrame, error) {
-	frame, err := video.NewFrameWithBufferAsArray(width, height, format, data)
-	if err != nil {
+	if c.src == nil {
+		c.src = video.NewFrame()
+	}
+
+	c.src.SetWidth(width)
+	c.src.SetHeight(height)
+	c.src.SetFormat(int(format))
+	if err := c.src.FillArrays(data); err != nil {
 		return nil, err
 	}
 
-	frame.SetLinesize([8]int{pitch})
-	return frame, nil
+	c.src.SetLinesize([8]int{pitch})
+	return c.src, nil
 }
 
 func (c *Converter) ConvertAndResize(swsCtxManager *SwsCtxManager, srcFrame *video.AVFrame, targetWidth, targetHeight int, targetFormat video.PixelFormat) (*video.AVFrame, error) {
+	rect := Rect{Width: targetWidth, Height: targetHeight}
+	return c.ConvertAndResizeInto(swsCtxManager, srcFrame, targetWidth, targetHeight, rect, targetFormat, video.SWS_BILINEAR)
+}
+
+// ConvertAndResizeInto scales srcFrame into rect of a black frame of targetWidth x targetHeight
+func (c *Converter) ConvertAndResizeInto(swsCtxManager *SwsCtxManager, srcFrame *video.AVFrame, targetWidth, targetHeight int, rect Rect, targetFormat video.PixelFormat, scalingAlgo int) (*video.AVFrame, error) {
+	desFrame, err := c.output(targetWidth, targetHeight, rect, targetFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	swsCtxKey := &SwsCtxKey{
 		from_width:  srcFrame.GetWidth(),
 		from_height: srcFrame.GetHeight(),
 		from_format: video.PixelFormat(srcFrame.GetFormat()),
 
-		to_width:  targetWidth,
-		to_height: targetHeight,
+		to_width:  rect.Width,
+		to_height: rect.Height,
 		to_format: targetFormat,
 
-		scalingAlgo: video.SWS_BILINEAR,
+		scalingAlgo: scalingAlgo,
 	}
 
 	swsCtx := swsCtxManager.Get(swsCtxKey)
 	defer swsCtxManager.Set(swsCtxKey, swsCtx)
 
-	return video.ScaleAndConvertFrame(swsCtx, srcFrame, targetWidth, targetHeight, targetFormat)
+	if err := video.ScaleAndConvertFrameInto(swsCtx, srcFrame, desFrame, rect.X, rect.Y, rect.Width, rect.Height); err != nil {
+		c.Release(desFrame)
+		return nil, err
+	}
+
+	return desFrame, nil
+}
+
+// output takes a destination frame from the pool, frames of another size or format are freed
+// and the frame is blacked out when the content moves
+func (c *Converter) output(width, height int, rect Rect, format video.PixelFormat) (*video.AVFrame, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var dst *video.AVFrame
+	for dst == nil && len(c.free) > 0 {
+		frame := c.free[len(c.free)-1]
+		c.free = c.free[:len(c.free)-1]
+
+		if frame.GetWidth() == width && frame.GetHeight() == height && video.PixelFormat(frame.GetFormat()) == format {
+			dst = frame
+			continue
+		}
+		delete(c.rects, frame)
+		frame.Close()
+	}
+
+	if dst == nil {
+		frame, err := video.NewFrameWithBuffer(width, height, format)
+		if err != nil {
+			return nil, err
+		}
+		dst = frame
+		c.rects[dst] = Rect{}
+	}
+
+	// the encoder may still reference the previous frame
+	if err := dst.MakeWritable(); err != nil {
+		c.free = append(c.free, dst)
+		return nil, err
+	}
+
+	fullFrame := rect == Rect{Width: width, Height: height}
+	if rect != c.rects[dst] && !fullFrame {
+		if err := dst.FillBlack(); err != nil {
+			c.free = append(c.free, dst)
+			return nil, err
+		}
+	}
+	c.rects[dst] = rect
+
+	return dst, nil
+}
+
+// Release gives a frame returned by ConvertAndResize or ConvertAndResizeInto back to the pool
+func (c *Converter) Release(frame *video.AVFrame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// freed by Close in the meantime
+	if _, ok := c.rects[frame]; !ok {
+		frame.Close()
+		return
+	}
+	c.free = append(c.free, frame)
+}
+
+// Close frees the pool, the frames that were not released yet are freed by Release
+func (c *Converter) Close() {
+	if c.src != nil {
+		c.src.Close()
+		c.src = nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, frame := range c.free {
+		frame.Close()
+	}
+	c.free = nil
+	c.rects = make(map[*video.AVFrame]Rect)
 }
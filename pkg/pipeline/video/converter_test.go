@@ -0,0 +1,113 @@
+package video
+
+import (
+	"cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/libretro"
+	"encoding/binary"
+	"testing"
+	"unsafe"
+)
+
+type rgb struct {
+	r, g, b byte
+}
+
+// golden pixels of each core format, only full or zero channels so that the bit expansion does not matter
+var converterGolden = []struct {
+	name   string
+	format uint32
+	pixels []uint32 // as the core writes them, native integers
+	want   []rgb
+}{
+	{
+		name:   "RGB565",
+		format: libretro.PixelFormatRGB565,
+		pixels: []uint32{0x0000, 0xffff, 0xf800, 0x07e0, 0x001f, 0xffe0, 0x07ff, 0xf81f},
+		want:   []rgb{{0, 0, 0}, {255, 255, 255}, {255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}, {0, 255, 255}, {255, 0, 255}},
+	},
+	{
+		name:   "0RGB1555",
+		format: libretro.PixelFormat0RGB1555,
+		pixels: []uint32{0x0000, 0x7fff, 0x7c00, 0x03e0, 0x001f, 0x7fe0, 0x03ff, 0x7c1f},
+		want:   []rgb{{0, 0, 0}, {255, 255, 255}, {255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}, {0, 255, 255}, {255, 0, 255}},
+	},
+	{
+		name:   "XRGB8888",
+		format: libretro.PixelFormatXRGB8888,
+		pixels: []uint32{0x000000, 0xffffff, 0xff0000, 0x00ff00, 0x0000ff, 0xffff00, 0x00ffff, 0xff00ff},
+		want:   []rgb{{0, 0, 0}, {255, 255, 255}, {255, 0, 0}, {0, 255, 0}, {0, 0, 255}, {255, 255, 0}, {0, 255, 255}, {255, 0, 255}},
+	},
+}
+
+const (
+	GOLDEN_FRAME_SIZE = 16
+	// rounding of the scaler
+	GOLDEN_TOLERANCE = 1
+)
+
+func TestConverterPixelFormats(t *testing.T) {
+	for _, tc := range converterGolden {
+		t.Run(tc.name, func(t *testing.T) {
+			pixelFmt, ok := pixelFormats[tc.format]
+			if !ok {
+				t.Fatalf("pixel format %d is not supported", tc.format)
+			}
+
+			for i, pixel := range tc.pixels {
+				got, err := convertSolidFrame(pixelFmt, pixel)
+				if err != nil {
+					t.Fatalf("convert pixel %#x failed: %v", pixel, err)
+				}
+
+				if !closeTo(got, tc.want[i]) {
+					t.Errorf("pixel %#x: got %v, want %v", pixel, got, tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+// convertSolidFrame fills a frame with pixel and returns the colour of its center once converted to RGB24
+func convertSolidFrame(pixelFmt PixelFmt, pixel uint32) (rgb, error) {
+	pitch := GOLDEN_FRAME_SIZE * pixelFmt.bpp
+	data := make([]byte, pitch*GOLDEN_FRAME_SIZE)
+	for i := 0; i < len(data); i += pixelFmt.bpp {
+		switch pixelFmt.bpp {
+		case 2:
+			binary.LittleEndian.PutUint16(data[i:], uint16(pixel))
+		case 4:
+			binary.LittleEndian.PutUint32(data[i:], pixel)
+		}
+	}
+
+	c := NewConverter()
+	defer c.Close()
+	swsManager := NewSwsCtxManager()
+	defer swsManager.Reset()
+
+	src, err := c.ToFrame(data, GOLDEN_FRAME_SIZE, GOLDEN_FRAME_SIZE, pitch, pixelFmt.avFormat)
+	if err != nil {
+		return rgb{}, err
+	}
+
+	dst, err := c.ConvertAndResize(swsManager, src, GOLDEN_FRAME_SIZE, GOLDEN_FRAME_SIZE, video.RGB)
+	if err != nil {
+		return rgb{}, err
+	}
+	defer c.Release(dst)
+
+	plane, linesize := dst.Plane(0)
+	out := unsafe.Slice((*byte)(plane), linesize*GOLDEN_FRAME_SIZE)
+
+	center := GOLDEN_FRAME_SIZE / 2
+	p := out[center*linesize+center*3:]
+	return rgb{p[0], p[1], p[2]}, nil
+}
+
+func closeTo(got, want rgb) bool {
+	near := func(a, b byte) bool {
+		d := int(a) - int(b)
+		return d >= -GOLDEN_TOLERANCE && d <= GOLDEN_TOLERANCE
+	}
+	return near(got.r, want.r) && near(got.g, want.g) && near(got.b, want.b)
+}
@@ -3,64 +3,42 @@ package video
 import (
 	"cloud_gaming/pkg/encoder"
 	"cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/log"
 	"errors"
+
+	"go.uber.org/zap"
 )
 
 type (
 	Encoder struct {
 		encoder.IVideoEncoder
-	}
-)
-
-func NewEncoder(codec video.VideoCodec, width, height int, pixFormat video.PixelFormat, fps float64) (*Encoder, error) {
-	e := &Encoder{}
 
-	enc, err := e.createEncoder(codec, width, height, pixFormat, fps)
-	if err != nil {
-		return nil, err
+		// Name of the implementation in use, e.g: "libx264"
+		Name string
 	}
 
-	return &Encoder{
-		IVideoEncoder: enc,
-	}, nil
-}
-
-func (e *Encoder) createEncoder(codec video.VideoCodec, width, height int,
-	pixFormat video.PixelFormat, fps float64) (encoder.IVideoEncoder, error) {
+	SendEncoderFunc func(name string, codec video.VideoCodec)
+)
 
-	switch codec {
-	case video.H264:
-		return e.createH264Encoder(width, height, pixFormat, fps)
-	case video.VP9:
-		return e.createVP9Encoder(width, height, pixFormat, fps)
-	default:
-		return nil, errors.New("codec not supported")
+// NewEncoder tries the registered encoders of codec in the order of names, the first one that opens is used
+func NewEncoder(codec video.VideoCodec, names []string, opts encoder.VideoEncoderOptions) (*Encoder, error) {
+	infos := encoder.VideoEncoders(codec, names)
+	if len(infos) == 0 {
+		return nil, errors.New("no encoder available for codec")
 	}
-}
 
-func (e *Encoder) createH264Encoder(width, height int,
-	pixFormat video.PixelFormat, fps float64) (encoder.IVideoEncoder, error) {
-
-	var err error
-	var enc encoder.IVideoEncoder
-
-	enc, err = encoder.NewH264Encoder(width, height, int(fps), pixFormat)
-	if err == nil {
-		return enc, nil
+	for _, info := range infos {
+		enc, err := info.New(opts)
+		if err != nil {
+			log.Warn("open encoder failed, trying the next one", zap.String("encoder", info.Name), zap.Error(err))
+			continue
+		}
+
+		return &Encoder{
+			IVideoEncoder: enc,
+			Name:          info.Name,
+		}, nil
 	}
 
-	return nil, err
-}
-
-func (e *Encoder) createVP9Encoder(width, height int,
-	pixFormat video.PixelFormat, fps float64) (encoder.IVideoEncoder, error) {
-
-	var err error
-	var enc encoder.IVideoEncoder
-
-	enc, err = encoder.NewVP9Encoder(width, height, int(fps), pixFormat)
-	if err == nil {
-		return enc, nil
-	}
-	return nil, err
+	return nil, errors.New("no encoder could be opened for codec")
 }
@@ -0,0 +1,50 @@
+package video
+
+import (
+	"cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/libretro"
+	"cloud_gaming/pkg/log"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+// GetSoftwareFramebuffer is called on EnvironmentGetCurrentSoftwareFramebuffer, the core renders its next frame
+// straight into a buffer of the pipeline instead of its own, which saves the core a copy.
+// The buffer is kept across frames and only reallocated when the requested size or the pixel format changes.
+func (v *VideoPipeline) GetSoftwareFramebuffer(data unsafe.Pointer) bool {
+	width, height := libretro.GetFramebufferSize(data)
+	if width <= 0 || height <= 0 {
+		return false
+	}
+
+	fb := v.framebuffer
+	if fb == nil || fb.GetWidth() != width || fb.GetHeight() != height || video.PixelFormat(fb.GetFormat()) != v.pixelFmt.avFormat {
+		v.closeFramebuffer()
+
+		var err error
+		fb, err = video.NewFrameWithBuffer(width, height, v.pixelFmt.avFormat)
+		if err != nil {
+			log.Error("allocate framebuffer failed", zap.Error(err))
+			return false
+		}
+		v.framebuffer = fb
+	}
+
+	pixels, pitch := fb.Plane(0)
+	libretro.SetFramebuffer(data, libretro.Framebuffer{
+		Data:   pixels,
+		Width:  width,
+		Height: height,
+		Pitch:  pitch,
+		Format: v.pixelFmt.format,
+	})
+	return true
+}
+
+func (v *VideoPipeline) closeFramebuffer() {
+	if v.framebuffer != nil {
+		v.framebuffer.Close()
+		v.framebuffer = nil
+	}
+}
@@ -0,0 +1,138 @@
+package video
+
+import "cloud_gaming/pkg/ffmpeg/video"
+
+type (
+	ScaleMode int
+
+	// OutputGeometry describes how the game screen is placed in the encoded frames
+	OutputGeometry struct {
+		Width    int     `json:"width"`  // encoded frame
+		Height   int     `json:"height"` // encoded frame
+		Content  Rect    `json:"content"`
+		Rotation int     `json:"rotation"` // counter-clockwise, in degrees
+		Aspect   float64 `json:"aspect"`   // display aspect ratio of the content
+	}
+
+	Rect struct {
+		X      int `json:"x"`
+		Y      int `json:"y"`
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	}
+
+	SendGeometryFunc func(OutputGeometry)
+)
+
+const (
+	// ScaleFit keeps the core's aspect ratio and fills the rest with black bars
+	ScaleFit ScaleMode = iota
+	// ScaleStretch fills the whole frame, ignoring the aspect ratio
+	ScaleStretch
+	// ScaleInteger scales the game screen by the largest integer factor that fits, pixels stay sharp
+	ScaleInteger
+)
+
+// aspectRatio returns the display aspect ratio of the game screen after rotation,
+// width and height are the size of the rotated frame, used when the core does not tell its geometry
+func (v *VideoPipeline) aspectRatio(width, height int) float64 {
+	aspect := v.geometry.AspectRatio
+	if aspect <= 0 {
+		if v.geometry.BaseWidth <= 0 || v.geometry.BaseHeight <= 0 {
+			return float64(width) / float64(height)
+		}
+		aspect = float64(v.geometry.BaseWidth) / float64(v.geometry.BaseHeight)
+	}
+
+	if v.angle%2 == 1 {
+		aspect = 1 / aspect
+	}
+	return aspect
+}
+
+// contentRect places a rotated frame of width x height in the output frame
+func (v *VideoPipeline) contentRect(width, height int) (Rect, int) {
+	full := Rect{Width: v.width, Height: v.height}
+
+	switch v.scaleMode {
+	case ScaleStretch:
+		return full, video.SWS_BILINEAR
+	case ScaleInteger:
+		if factor := min(v.width/width, v.height/height); factor >= 1 {
+			return centerRect(v.width, v.height, width*factor, height*factor), video.SWS_POINT
+		}
+	}
+
+	// ScaleFit, and ScaleInteger when the game screen is larger than the output
+	aspect := v.aspectRatio(width, height)
+	w, h := v.width, int(float64(v.width)/aspect)
+	if h > v.height {
+		w, h = int(float64(v.height)*aspect), v.height
+	}
+	return centerRect(v.width, v.height, w, h), video.SWS_BILINEAR
+}
+
+// centerRect keeps positions and sizes even, as required by yuv420
+func centerRect(width, height, w, h int) Rect {
+	w = min(toEven(float64(w)), width)
+	h = min(toEven(float64(h)), height)
+
+	return Rect{
+		X:      (width - w) / 2 &^ 1,
+		Y:      (height - h) / 2 &^ 1,
+		Width:  w,
+		Height: h,
+	}
+}
+
+// rotate turns a frame by angle * 90 degrees counter-clockwise, as set by EnvironmentSetRotation.
+// The result is written in buf, which is grown if needed, and is tightly packed.
+func rotate(buf, data []byte, width, height, pitch, bpp, angle int) ([]byte, int, int, int) {
+	outWidth, outHeight := width, height
+	if angle%2 == 1 {
+		outWidth, outHeight = height, width
+	}
+	outPitch := outWidth * bpp
+
+	size := outPitch * outHeight
+	if cap(buf) < size {
+		buf = make([]byte, size)
+	}
+	buf = buf[:size]
+
+	for y := 0; y < height; y++ {
+		row := data[y*pitch : y*pitch+width*bpp]
+		for x := 0; x < width; x++ {
+			var ox, oy int
+			switch angle {
+			case 1:
+				ox, oy = y, width-1-x
+			case 2:
+				ox, oy = width-1-x, height-1-y
+			case 3:
+				ox, oy = height-1-y, x
+			default:
+				ox, oy = x, y
+			}
+
+			copy(buf[oy*outPitch+ox*bpp:], row[x*bpp:(x+1)*bpp])
+		}
+	}
+
+	return buf, outWidth, outHeight, outPitch
+}
+
+// unrotate maps a position in the rotated game screen back to the core's coordinates,
+// both in [-0x7fff, 0x7fff]
+func unrotate(x, y int16, angle int) (int16, int16) {
+	switch angle {
+	case 1:
+		return -y, x
+	case 2:
+		return -x, -y
+	case 3:
+		return y, -x
+	default:
+		return x, y
+	}
+}
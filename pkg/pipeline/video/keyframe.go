@@ -0,0 +1,26 @@
+package video
+
+import "time"
+
+const (
+	// clients send a PLI for each lost frame until they get a keyframe,
+	// requests closer than this are served by the same keyframe
+	MIN_KEYFRAME_INTERVAL = 300 * time.Millisecond
+)
+
+// RequestKeyFrame is called when a client cannot decode the stream anymore (PLI/FIR),
+// the keyframe is requested before the next encoded frame
+func (v *VideoPipeline) RequestKeyFrame() {
+	v.keyFrameRequested.Store(true)
+}
+
+// applyKeyFrameRequest runs on the encoding goroutine, right before encoding
+func (v *VideoPipeline) applyKeyFrameRequest(s *encodingState) {
+	if !v.keyFrameRequested.Load() || time.Since(s.lastKeyFrame) < MIN_KEYFRAME_INTERVAL {
+		return
+	}
+
+	v.keyFrameRequested.Store(false)
+	s.lastKeyFrame = time.Now()
+	s.enc.RequestKeyFrame()
+}
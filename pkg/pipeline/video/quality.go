@@ -0,0 +1,49 @@
+package video
+
+import "cloud_gaming/pkg/ffmpeg/video"
+
+type (
+	// Quality sets the encoded stream, a zero size follows the game geometry
+	Quality struct {
+		Width   int
+		Height  int
+		Bitrate int // bits per second
+		CRF     int
+		Codec   video.VideoCodec
+	}
+)
+
+func DefaultQuality() Quality {
+	return Quality{
+		Bitrate: 2000000,
+		CRF:     23,
+		Codec:   video.H264,
+	}
+}
+
+// SetQuality is applied before the next frame, or when the pipeline starts
+func (v *VideoPipeline) SetQuality(quality Quality) {
+	v.pendingQuality.Store(&quality)
+}
+
+// applyPendingQuality runs on the emulator thread
+func (v *VideoPipeline) applyPendingQuality() {
+	quality := v.pendingQuality.Swap(nil)
+	if quality == nil || *quality == v.quality {
+		return
+	}
+
+	v.quality = *quality
+	v.codec = quality.Codec
+	v.updateOutputSize()
+	v.onOutputChanged()
+}
+
+// fitSize returns the largest size in maxWidth x maxHeight with the given aspect ratio
+func fitSize(maxWidth, maxHeight int, aspect float64) (int, int) {
+	w, h := maxWidth, int(float64(maxWidth)/aspect)
+	if h > maxHeight {
+		w, h = int(float64(maxHeight)*aspect), maxHeight
+	}
+	return toEven(float64(w)), toEven(float64(h))
+}
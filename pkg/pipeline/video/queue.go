@@ -0,0 +1,142 @@
+package video
+
+import (
+	"cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/log"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+type (
+	// frameQueue hands converted frames from the emulator thread to the goroutine encoding them,
+	// when the encoder falls behind the oldest frame is dropped so that the core never waits for it
+	frameQueue struct {
+		frames chan queuedFrame
+		stop   chan struct{}
+		done   chan struct{}
+	}
+
+	queuedFrame struct {
+		frame    *video.AVFrame
+		pts      time.Duration
+		queuedAt time.Time
+		// config is the encoder the frame was converted for
+		config encoderConfig
+	}
+
+	// encodingState is owned by the goroutine encoding the frames of a queue,
+	// the encoder is only ever created, reconfigured and closed there
+	encodingState struct {
+		enc    *Encoder
+		config encoderConfig
+		// failed is set when no encoder could be created with config, it is retried once config changes
+		failed bool
+		// name is the implementation in use, reported to the client when it changes
+		name         string
+		lastKeyFrame time.Time
+	}
+
+	EncoderStats struct {
+		Dropped uint64 // frames dropped because the encoder was behind
+		Late    uint64 // frames encoded more than a frame interval after the core produced them
+	}
+)
+
+const (
+	// FRAME_QUEUE_SIZE is how many frames may wait for the encoder, more adds latency
+	FRAME_QUEUE_SIZE = 2
+)
+
+func newFrameQueue() *frameQueue {
+	return &frameQueue{
+		frames: make(chan queuedFrame, FRAME_QUEUE_SIZE),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// startEncoding runs the goroutine encoding the frames of q, the encoder is created with the first frame
+// and closed when the goroutine stops
+func (v *VideoPipeline) startEncoding(q *frameQueue) {
+	go func() {
+		defer close(q.done)
+
+		s := &encodingState{}
+		defer v.closeEncoder(s)
+
+		for {
+			select {
+			case <-q.stop:
+				return
+			case f := <-q.frames:
+				v.encodeFrame(s, f)
+			}
+		}
+	}()
+}
+
+func (v *VideoPipeline) encodeFrame(s *encodingState, f queuedFrame) {
+	defer v.converter.Release(f.frame)
+
+	v.updateEncoder(s, f.config)
+	if s.enc == nil {
+		return
+	}
+
+	v.applyKeyFrameRequest(s)
+	if err := s.enc.Encode(f.frame, f.pts); err != nil {
+		log.Error("encode failed", zap.Error(err))
+	}
+	if time.Since(f.queuedAt) > f.config.interval() {
+		v.lateFrames.Add(1)
+	}
+}
+
+// queueFrame runs on the emulator thread and never blocks, the oldest frame is dropped when q is full
+func (v *VideoPipeline) queueFrame(q *frameQueue, frame *video.AVFrame, pts time.Duration, config encoderConfig) {
+	f := queuedFrame{frame: frame, pts: pts, queuedAt: time.Now(), config: config}
+
+	for {
+		select {
+		case q.frames <- f:
+			return
+		default:
+		}
+
+		// the encoder may take a frame in the meantime
+		select {
+		case oldest := <-q.frames:
+			v.dropFrame(oldest)
+		default:
+		}
+	}
+}
+
+// stopEncoding waits for the frame being encoded and the encoder to be closed, the queued frames are dropped
+func (v *VideoPipeline) stopEncoding(q *frameQueue) {
+	close(q.stop)
+	<-q.done
+
+	for {
+		select {
+		case f := <-q.frames:
+			v.dropFrame(f)
+		default:
+			return
+		}
+	}
+}
+
+func (v *VideoPipeline) dropFrame(f queuedFrame) {
+	v.droppedFrames.Add(1)
+	v.converter.Release(f.frame)
+}
+
+// EncoderStats can be called from any goroutine
+func (v *VideoPipeline) EncoderStats() EncoderStats {
+	return EncoderStats{
+		Dropped: v.droppedFrames.Load(),
+		Late:    v.lateFrames.Load(),
+	}
+}
@@ -2,6 +2,8 @@ package video
 
 import (
 	"cloud_gaming/pkg/ffmpeg/video"
+	"slices"
+	"sync"
 )
 
 type (
@@ -17,11 +19,23 @@ type (
 		scalingAlgo int
 	}
 
+	// SwsCtxManager caches scaling contexts, it is safe for concurrent use
 	SwsCtxManager struct {
+		mu     sync.Mutex
 		ctxMap map[SwsCtxKey]chan *video.SwsContext
+		// recent orders the keys from the most recently used one
+		recent []SwsCtxKey
 	}
 )
 
+const (
+	// MAX_SWS_CTX_KEYS bounds the cached conversions, the least recently used one is freed first.
+	// The core may switch resolutions mid-game, e.g: interlaced modes, so a few are kept around
+	MAX_SWS_CTX_KEYS = 4
+	// MAX_SWS_CTX_PER_KEY bounds the idle contexts kept for a conversion
+	MAX_SWS_CTX_PER_KEY = 2
+)
+
 func NewSwsCtxManager() *SwsCtxManager {
 	return &SwsCtxManager{
 		ctxMap: make(map[SwsCtxKey]chan *video.SwsContext),
@@ -29,35 +43,83 @@ func NewSwsCtxManager() *SwsCtxManager {
 }
 
 func (c *SwsCtxManager) Get(k *SwsCtxKey) *video.SwsContext {
+	c.mu.Lock()
 	channel, ok := c.ctxMap[*k]
 	if !ok {
-		c.ctxMap[*k] = make(chan *video.SwsContext, 100)
+		channel = make(chan *video.SwsContext, MAX_SWS_CTX_PER_KEY)
+		c.ctxMap[*k] = channel
 	}
+	c.touch(*k)
+	c.mu.Unlock()
 
-	if len(channel) == 0 {
-		c.ctxMap[*k] <- video.NewSwsCtx(k.from_width, k.from_height, k.to_width,
+	select {
+	case swsCtx := <-channel:
+		return swsCtx
+	default:
+		return video.NewSwsCtx(k.from_width, k.from_height, k.to_width,
 			k.to_height, k.from_format, k.to_format, k.scalingAlgo)
 	}
-
-	return <-c.ctxMap[*k]
 }
 
 func (c *SwsCtxManager) Set(k *SwsCtxKey, swsCtx *video.SwsContext) {
-	_, ok := c.ctxMap[*k]
+	if swsCtx == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// evicted or reset in the meantime
+	channel, ok := c.ctxMap[*k]
 	if !ok {
 		swsCtx.Free()
 		return
 	}
 
-	c.ctxMap[*k] <- swsCtx
+	select {
+	case channel <- swsCtx:
+	default:
+		swsCtx.Free()
+	}
+}
+
+// touch marks k as the most recently used key and evicts the least recently used one past MAX_SWS_CTX_KEYS,
+// c.mu must be held
+func (c *SwsCtxManager) touch(k SwsCtxKey) {
+	if i := slices.Index(c.recent, k); i >= 0 {
+		c.recent = slices.Delete(c.recent, i, i+1)
+	}
+	c.recent = slices.Insert(c.recent, 0, k)
+
+	for len(c.recent) > MAX_SWS_CTX_KEYS {
+		oldest := c.recent[len(c.recent)-1]
+		c.recent = c.recent[:len(c.recent)-1]
+
+		freeAll(c.ctxMap[oldest])
+		delete(c.ctxMap, oldest)
+	}
 }
 
 func (c *SwsCtxManager) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	for _, channel := range c.ctxMap {
-		for len(channel) > 0 {
-			(<-channel).Free()
-		}
+		freeAll(channel)
 	}
 
 	c.ctxMap = make(map[SwsCtxKey]chan *video.SwsContext)
+	c.recent = nil
+}
+
+// freeAll frees the idle contexts of channel, the ones in use are freed when they are given back
+func freeAll(channel chan *video.SwsContext) {
+	for {
+		select {
+		case swsCtx := <-channel:
+			swsCtx.Free()
+		default:
+			return
+		}
+	}
 }
@@ -1,9 +1,12 @@
 package video
 
 import (
+	"cloud_gaming/pkg/encoder"
 	"cloud_gaming/pkg/ffmpeg/video"
 	"cloud_gaming/pkg/libretro"
 	"cloud_gaming/pkg/log"
+	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"go.uber.org/zap"
@@ -13,27 +16,75 @@ type (
 	VideoPipeline struct {
 		swsManager *SwsCtxManager
 		converter  *Converter
-		enc        *Encoder
+		// queue feeds the encoding goroutine from the emulator thread, nil until the pipeline starts
+		queue *frameQueue
+
+		// frames that did not make it to the encoder in time, read from other goroutines
+		droppedFrames atomic.Uint64
+		lateFrames    atomic.Uint64
 
 		// pixelFmt, angle will be set by coreEnvironment once the core is loaded
 		// will be consistent through core's lifetime
 		pixelFmt *PixelFmt
 		angle    int
 
+		// output resolution, follows the game geometry
 		height    int
 		width     int
 		codec     video.VideoCodec
 		pixFormat video.PixelFormat
 
-		// fps will be set once game is loaded
-		fps float64
+		// fps and geometry will be set once game is loaded, the core may change them mid-game
+		fps      float64
+		geometry libretro.GameGeometry
+
+		scaleMode ScaleMode
+		rotateBuf []byte
+
+		// bound of the output resolution whatever the quality and scale mode, 0 if unbounded
+		maxWidth  int
+		maxHeight int
+
+		// framebuffer is handed to the core on EnvironmentGetCurrentSoftwareFramebuffer, nil until requested
+		framebuffer *video.AVFrame
+
+		quality        Quality
+		pendingQuality atomic.Pointer[Quality]
+
+		// congestion control, estimate is the latest bandwidth estimate (0 if unknown)
+		pendingBitrate atomic.Int64
+		estimate       int
+		level          int // index in degradations
+		frameCount     uint64
+
+		keyFrameRequested atomic.Bool
+
+		// output is the last geometry reported to the client, nil until the first frame
+		output atomic.Pointer[OutputGeometry]
 
 		sendVideoFrame SendVideoFrameFunc
+		sendGeometry   SendGeometryFunc
+		sendEncoder    SendEncoderFunc
+
+		// encoders is the order in which encoder implementations are tried, all of them if empty
+		encoders []string
+	}
+
+	// encoderConfig is what an encoder is created with, it travels with each frame to the encoding goroutine
+	encoderConfig struct {
+		codec     video.VideoCodec
+		width     int
+		height    int
+		fps       float64
+		pixFormat video.PixelFormat
+		bitrate   int
+		crf       int
 	}
 
 	PixelFmt struct {
-		format uint32
-		bpp    int //bytes per pixel
+		format   uint32
+		bpp      int //bytes per pixel
+		avFormat video.PixelFormat
 	}
 
 	VideoFrame struct {
@@ -43,141 +94,420 @@ type (
 		Width    int               `json:"width"`
 		Height   int               `json:"height"`
 		Duration float64           // in milliseconds
+		PTS      time.Duration     // on the session timeline, shared with audio
 	}
 
 	SendVideoFrameFunc func(*VideoFrame)
 )
 
-func NewVideoPipeline(sendVideoFrame SendVideoFrameFunc) (*VideoPipeline, error) {
+const (
+	// OUTPUT_SCALE is the ratio between the output resolution and the game's base resolution
+	OUTPUT_SCALE = 1.5
+	// INTEGER_OUTPUT_SCALE replaces OUTPUT_SCALE in ScaleInteger mode
+	INTEGER_OUTPUT_SCALE = 2
+)
+
+func NewVideoPipeline(sendVideoFrame SendVideoFrameFunc, sendGeometry SendGeometryFunc, sendEncoder SendEncoderFunc) (*VideoPipeline, error) {
 	v := &VideoPipeline{
 		swsManager:     NewSwsCtxManager(),
 		converter:      NewConverter(),
 		sendVideoFrame: sendVideoFrame,
+		sendGeometry:   sendGeometry,
+		sendEncoder:    sendEncoder,
 		width:          256 * 1.5,
 		height:         240 * 1.5,
 		codec:          video.H264,
 		pixFormat:      video.YUV420,
+		pixelFmt:       defaultPixelFmt(),
+		quality:        DefaultQuality(),
 	}
 
 	return v, nil
 }
 
 func (v *VideoPipeline) Start() {
-	enc, err := NewEncoder(v.codec, v.width, v.height, v.pixFormat, v.fps)
+	if quality := v.pendingQuality.Swap(nil); quality != nil {
+		v.quality = *quality
+		v.codec = quality.Codec
+		v.updateOutputSize()
+	}
+	v.queue = newFrameQueue()
+	v.startEncoding(v.queue)
+}
+
+// encoderConfig runs on the emulator thread, the frame being queued is converted for it
+func (v *VideoPipeline) encoderConfig() encoderConfig {
+	return encoderConfig{
+		codec:     v.codec,
+		width:     v.width,
+		height:    v.height,
+		fps:       v.fps / float64(v.degradation().fpsDivisor),
+		pixFormat: v.pixFormat,
+		bitrate:   v.encoderBitrate(),
+		crf:       v.quality.CRF,
+	}
+}
+
+func (c encoderConfig) interval() time.Duration {
+	return time.Duration(float64(time.Second) / c.fps)
+}
+
+// SetEncoders sets the order in which encoder implementations are tried, e.g: libx264 then libopenh264.
+// It must be called before the pipeline starts.
+func (v *VideoPipeline) SetEncoders(names []string) {
+	v.encoders = names
+}
+
+// SetMaxSize bounds the output resolution, it is scaled down keeping its aspect ratio.
+// It must be called before the pipeline starts.
+func (v *VideoPipeline) SetMaxSize(width, height int) {
+	v.maxWidth, v.maxHeight = width, height
+}
+
+// updateEncoder runs on the encoding goroutine, the encoder follows the config of the frames
+func (v *VideoPipeline) updateEncoder(s *encodingState, config encoderConfig) {
+	started := s.enc != nil || s.failed
+	if started && config == s.config {
+		return
+	}
+
+	sameOutput := s.config
+	sameOutput.bitrate = config.bitrate
+	if s.enc != nil && sameOutput == config {
+		v.applyBitrate(s, config)
+		return
+	}
+
+	v.restartEncoder(s, config)
+}
+
+// restartEncoder runs on the encoding goroutine and replaces the encoder after the output resolution, fps
+// or quality changed, frames keep flowing to the same track so the WebRTC session is untouched
+func (v *VideoPipeline) restartEncoder(s *encodingState, config encoderConfig) {
+	v.closeEncoder(s)
+	s.config = config
+
+	enc, err := NewEncoder(config.codec, v.encoders, encoder.VideoEncoderOptions{
+		Width:   config.width,
+		Height:  config.height,
+		FPS:     int(config.fps),
+		PixFmt:  config.pixFormat,
+		Bitrate: config.bitrate,
+		CRF:     config.crf,
+	})
 	if err != nil {
 		log.Error("create encoder failed", zap.Error(err))
+		s.failed = true
+		return
+	}
+	s.enc, s.failed = enc, false
+
+	if enc.Name != s.name {
+		log.Info("video encoder started", zap.String("encoder", enc.Name))
+		s.name = enc.Name
+		if v.sendEncoder != nil {
+			v.sendEncoder(enc.Name, config.codec)
+		}
+	}
+
+	go v.sendEncodedFrames(enc, config)
+}
+
+func (v *VideoPipeline) closeEncoder(s *encodingState) {
+	if s.enc == nil {
 		return
 	}
-	v.enc = enc
 
-	go v.getEncodedDataAndSendFrame()
+	if err := s.enc.Close(); err != nil {
+		log.Error("close encoder failed", zap.Error(err))
+	}
+	s.enc = nil
 }
 
+// SetSystemVideoInfo is called once the game is loaded and on EnvironmentSetSystemAVInfo
 func (v *VideoPipeline) SetSystemVideoInfo(systemAVInfo *libretro.SystemAVInfo) {
+	changed := v.fps != systemAVInfo.Timing.FPS
 	v.fps = systemAVInfo.Timing.FPS
+
+	if v.setGeometry(systemAVInfo.Geometry) || changed {
+		v.onOutputChanged()
+	}
 }
 
-func (v *VideoPipeline) SetPixelFormat(data unsafe.Pointer) {
-	fmt := libretro.GetPixelFormat(data)
-	log.Debug("pixel fmt: ", zap.Uint32("pixFmt", fmt))
+// SetGeometry is called on EnvironmentSetGeometry, e.g: when the game switches to an interlaced mode
+func (v *VideoPipeline) SetGeometry(geometry libretro.GameGeometry) {
+	if v.setGeometry(geometry) {
+		v.onOutputChanged()
+	}
+}
 
-	switch fmt {
-	case libretro.PixelFormat0RGB1555:
-		v.pixelFmt = &PixelFmt{
-			format: libretro.PixelFormat0RGB1555,
-			bpp:    2,
-		}
-	case libretro.PixelFormatXRGB8888:
-		v.pixelFmt = &PixelFmt{
-			format: libretro.PixelFormatXRGB8888,
-			bpp:    4,
+// setGeometry returns whether the output resolution changed
+func (v *VideoPipeline) setGeometry(geometry libretro.GameGeometry) bool {
+	v.geometry = geometry
+	return v.updateOutputSize()
+}
+
+// SetScaleMode should be called before the game starts
+func (v *VideoPipeline) SetScaleMode(mode ScaleMode) {
+	v.scaleMode = mode
+	if v.updateOutputSize() {
+		v.onOutputChanged()
+	}
+}
+
+// updateOutputSize derives the output resolution from the game geometry, rotation and scale mode,
+// it returns whether the output resolution changed
+func (v *VideoPipeline) updateOutputSize() bool {
+	baseWidth, baseHeight := v.geometry.BaseWidth, v.geometry.BaseHeight
+	if baseWidth <= 0 || baseHeight <= 0 {
+		return false
+	}
+
+	if v.angle%2 == 1 {
+		baseWidth, baseHeight = baseHeight, baseWidth
+	}
+
+	var width, height int
+	if v.quality.Width > 0 && v.quality.Height > 0 {
+		width, height = v.boxedSize(baseWidth, baseHeight)
+	} else {
+		switch v.scaleMode {
+		case ScaleInteger:
+			width = toEven(float64(baseWidth * INTEGER_OUTPUT_SCALE))
+			height = toEven(float64(baseHeight * INTEGER_OUTPUT_SCALE))
+		default:
+			height = toEven(float64(baseHeight) * OUTPUT_SCALE)
+			width = toEven(float64(height) * v.aspectRatio(baseWidth, baseHeight))
 		}
-	case libretro.PixelFormatRGB565:
-		v.pixelFmt = &PixelFmt{
-			format: libretro.PixelFormatRGB565,
-			bpp:    2,
+	}
+
+	if v.maxWidth > 0 && v.maxHeight > 0 && (width > v.maxWidth || height > v.maxHeight) {
+		width, height = fitSize(v.maxWidth, v.maxHeight, float64(width)/float64(height))
+	}
+
+	// lowered by the congestion controller
+	if scale := v.degradation().scale; scale < 1 {
+		width, height = toEven(float64(width)*scale), toEven(float64(height)*scale)
+	}
+
+	if width == v.width && height == v.height {
+		return false
+	}
+
+	v.width, v.height = width, height
+	return true
+}
+
+// boxedSize fits the game screen in the resolution of the quality preset,
+// only ScaleStretch uses the whole preset resolution
+func (v *VideoPipeline) boxedSize(baseWidth, baseHeight int) (int, int) {
+	boxWidth, boxHeight := v.quality.Width, v.quality.Height
+
+	switch v.scaleMode {
+	case ScaleStretch:
+		return toEven(float64(boxWidth)), toEven(float64(boxHeight))
+	case ScaleInteger:
+		if factor := min(boxWidth/baseWidth, boxHeight/baseHeight); factor >= 1 {
+			return toEven(float64(baseWidth * factor)), toEven(float64(baseHeight * factor))
 		}
 	}
+
+	return fitSize(boxWidth, boxHeight, v.aspectRatio(baseWidth, baseHeight))
 }
 
+func (v *VideoPipeline) onOutputChanged() {
+	// not started yet, the encoder will be created with the new settings
+	if v.queue == nil {
+		return
+	}
+
+	// the encoding goroutine restarts the encoder when the next frame comes with the new settings
+	log.Info("video output changed", zap.Int("width", v.width), zap.Int("height", v.height), zap.Float64("fps", v.fps))
+	v.swsManager.Reset()
+}
+
+// toEven rounds down to an even size, as required by yuv420
+func toEven(size float64) int {
+	return max(int(size)&^1, 2)
+}
+
+// pixelFormats maps libretro formats to ffmpeg ones, the core writes pixels as native integers
+var pixelFormats = map[uint32]PixelFmt{
+	libretro.PixelFormat0RGB1555: {
+		format:   libretro.PixelFormat0RGB1555,
+		bpp:      2,
+		avFormat: video.RGB555LE,
+	},
+	libretro.PixelFormatXRGB8888: {
+		format:   libretro.PixelFormatXRGB8888,
+		bpp:      4,
+		avFormat: video.BGR0,
+	},
+	libretro.PixelFormatRGB565: {
+		format:   libretro.PixelFormatRGB565,
+		bpp:      2,
+		avFormat: video.RGB565LE,
+	},
+}
+
+// defaultPixelFmt is used by cores that never set their pixel format
+func defaultPixelFmt() *PixelFmt {
+	pixelFmt := pixelFormats[libretro.PixelFormat0RGB1555]
+	return &pixelFmt
+}
+
+// SetPixelFormat returns false for formats the pipeline cannot convert, the core has to pick another one
+func (v *VideoPipeline) SetPixelFormat(data unsafe.Pointer) bool {
+	fmt := libretro.GetPixelFormat(data)
+	log.Debug("pixel fmt: ", zap.Uint32("pixFmt", fmt))
+
+	pixelFmt, ok := pixelFormats[fmt]
+	if !ok {
+		return false
+	}
+
+	v.pixelFmt = &pixelFmt
+	return true
+}
+
+// SetRotation is called on EnvironmentSetRotation, data is the number of 90 degrees counter-clockwise rotations
 func (v *VideoPipeline) SetRotation(data unsafe.Pointer) {
-	v.angle = int(uintptr(data)) % 4
+	v.angle = int(*(*uint32)(data)) % 4
+	if v.updateOutputSize() {
+		v.onOutputChanged()
+	}
 }
 
-func (v *VideoPipeline) Process(data []byte, width, height, pitch int32) {
+// ToScreenPos maps a position in the output resolution to libretro's screen coordinates,
+// [-0x7fff, 0x7fff] on both axes, offscreen is true when the position is outside of the game screen.
+// Black bars are offscreen and the rotation is undone, so the core gets positions in its own orientation.
+func (v *VideoPipeline) ToScreenPos(x, y int) (int16, int16, bool) {
+	output := v.output.Load()
+	if output == nil {
+		return 0, 0, true
+	}
+
+	r := output.Content
+	if r.Width <= 0 || r.Height <= 0 {
+		return 0, 0, true
+	}
+
+	x -= r.X
+	y -= r.Y
+	offscreen := x < 0 || y < 0 || x >= r.Width || y >= r.Height
+	x = min(max(x, 0), r.Width-1)
+	y = min(max(y, 0), r.Height-1)
+
+	sx, sy := unrotate(toScreenCoord(x, r.Width), toScreenCoord(y, r.Height), output.Rotation/90)
+	return sx, sy, offscreen
+}
+
+func toScreenCoord(pos, size int) int16 {
+	if size <= 1 {
+		return 0
+	}
+	return int16(pos*0xfffe/(size-1) - 0x7fff)
+}
+
+// Process converts a frame of the core and queues it for encoding, pts is the time the emulated frame started
+// on the session timeline
+func (v *VideoPipeline) Process(data []byte, width, height, pitch int32, pts time.Duration) {
 	var (
 		rgbFrame *video.AVFrame
 		err      error
 	)
 
-	switch v.pixelFmt.format {
-	// RGB
-	case libretro.PixelFormat0RGB1555:
-		rgbFrame, err = v.converter.ToFrame(data, int(width), int(height), int(pitch), video.RGB)
-		// RGB
-	case libretro.PixelFormatRGB565:
-		rgbFrame, err = v.converter.ToFrame(data, int(width), int(height), int(pitch), video.RGB)
-		// RGBA
-	case libretro.PixelFormatXRGB8888:
-		rgbFrame, err = v.converter.ToFrame(data, int(width), int(height), int(pitch), video.RGBA)
+	v.applyPendingQuality()
+	v.applyTargetBitrate()
+	if v.skipFrame() {
+		return
+	}
+
+	if v.angle != 0 {
+		var w, h, p int
+		v.rotateBuf, w, h, p = rotate(v.rotateBuf, data, int(width), int(height), int(pitch), v.pixelFmt.bpp, v.angle)
+		data, width, height, pitch = v.rotateBuf, int32(w), int32(h), int32(p)
 	}
 
+	rgbFrame, err = v.converter.ToFrame(data, int(width), int(height), int(pitch), v.pixelFmt.avFormat)
 	if err != nil {
 		log.Error("convert failed", zap.Error(err))
 		return
 	}
-	defer rgbFrame.Close()
 
-	frame, err := v.converter.ConvertAndResize(v.swsManager, rgbFrame, v.width, v.height, v.pixFormat)
+	rect, scalingAlgo := v.contentRect(int(width), int(height))
+	v.reportGeometry(rect, int(width), int(height))
+
+	var frame *video.AVFrame
+	if rect.Width == v.width && rect.Height == v.height {
+		frame, err = v.converter.ConvertAndResize(v.swsManager, rgbFrame, v.width, v.height, v.pixFormat)
+	} else {
+		frame, err = v.converter.ConvertAndResizeInto(v.swsManager, rgbFrame, v.width, v.height, rect, v.pixFormat, scalingAlgo)
+	}
 	if err != nil {
 		log.Error("convert and resize failed", zap.Error(err))
 		return
 	}
-	defer frame.Close()
 
-	if v.enc == nil {
+	if v.queue == nil {
+		v.converter.Release(frame)
 		return
 	}
 
-	err = v.enc.Encode(frame)
-	if err != nil {
-		log.Error("encode failed", zap.Error(err))
-		return
-	}
+	// encoded on another goroutine, so that a slow encoder does not slow the game down
+	v.queueFrame(v.queue, frame, pts, v.encoderConfig())
 }
 
-func (v *VideoPipeline) getEncodedDataAndSendFrame() {
-	for {
-		if v.enc == nil {
-			break
-		}
+// reportGeometry tells the client where the game screen is in the frames, when it changes
+func (v *VideoPipeline) reportGeometry(rect Rect, width, height int) {
+	output := OutputGeometry{
+		Width:    v.width,
+		Height:   v.height,
+		Content:  rect,
+		Rotation: v.angle * 90,
+		Aspect:   v.aspectRatio(width, height),
+	}
 
-		data, err := v.enc.GetEncodedData()
-		if err != nil {
-			log.Debug("get encoded data failed", zap.Error(err))
-			break
-		}
+	if last := v.output.Load(); last != nil && *last == output {
+		return
+	}
 
-		if data == nil {
-			continue
-		}
+	v.output.Store(&output)
+	if v.sendGeometry != nil {
+		v.sendGeometry(output)
+	}
+}
 
+// sendEncodedFrames runs until enc is closed, config is the one enc was created with
+func (v *VideoPipeline) sendEncodedFrames(enc *Encoder, config encoderConfig) {
+	for pkt := range enc.Packets() {
 		v.sendVideoFrame(&VideoFrame{
-			Data:     data,
-			Codec:    v.codec,
-			Format:   v.pixFormat,
-			Width:    v.width,
-			Height:   v.height,
-			Duration: 1 / v.fps * 1000,
+			Data:     pkt.Data,
+			Codec:    config.codec,
+			Format:   config.pixFormat,
+			Width:    config.width,
+			Height:   config.height,
+			Duration: 1 / config.fps * 1000,
+			PTS:      pkt.PTS,
 		})
 	}
 
-	log.Debug("getEncodedDataAndSendFrame has stopped")
+	log.Debug("sendEncodedFrames has stopped")
 }
 
 func (v *VideoPipeline) Close() error {
-	v.enc.Close()
+	if v.queue != nil {
+		v.stopEncoding(v.queue)
+		v.queue = nil
+	}
+	v.droppedFrames.Store(0)
+	v.lateFrames.Store(0)
+
+	v.pixelFmt = defaultPixelFmt()
 	v.swsManager.Reset()
+	v.converter.Close()
+	v.closeFramebuffer()
+	v.output.Store(nil)
 
 	return nil
 }
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+const (
+	movieDir        = "movie"
+	movieFileSuffix = ".movie"
+)
+
+// SaveMovie stores an input movie alongside the game, overriding the previous one with the same name
+func (s *Storage) SaveMovie(game, name string, data []byte) error {
+	path, err := s.moviePath(game, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+// ValidateMovieName reports whether a movie of game can be saved under name
+func (s *Storage) ValidateMovieName(game, name string) error {
+	_, err := s.moviePath(game, name)
+	return err
+}
+
+func (s *Storage) LoadMovie(game, name string) ([]byte, error) {
+	path, err := s.moviePath(game, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.ReadFile(path)
+}
+
+// moviePath returns <game dir>/movie/<game>/<name>.movie,
+// movies are kept in a sub-directory so they are not listed as games
+func (s *Storage) moviePath(game, name string) (string, error) {
+	if !isValidName(name) {
+		return "", errors.New("invalid movie name")
+	}
+
+	gameMeta, err := s.GetGameMetadata(game)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(gameMeta.Path), movieDir, gameMeta.Name, name+movieFileSuffix), nil
+}
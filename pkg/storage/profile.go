@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// InputProfile maps keyboard keys and gamepad buttons to retropad ids
+	InputProfile struct {
+		Keyboard map[uint]uint `json:"keyboard"` // retro_key -> retropad id
+		Gamepad  map[uint]uint `json:"gamepad"`  // gamepad button -> retropad id
+	}
+)
+
+const (
+	profileDir        = "./pkg/storage/profile"
+	defaultProfile    = "default.json"
+	gameProfileDir    = "game"
+	userProfileDir    = "user"
+	profileFileSuffix = ".json"
+)
+
+// GetInputProfiles returns the default, per-game and per-user profiles in that order.
+// Missing profiles are skipped, so later ones should override earlier ones.
+func (s *Storage) GetInputProfiles(user, game string) ([]InputProfile, error) {
+	paths := []string{filepath.Join(profileDir, defaultProfile)}
+
+	if game != "" {
+		path, err := profilePath(gameProfileDir, game)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	if user != "" {
+		path, err := profilePath(userProfileDir, user)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+
+	res := make([]InputProfile, 0, len(paths))
+	for _, path := range paths {
+		profile, err := loadInputProfile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, profile)
+	}
+
+	return res, nil
+}
+
+// SaveUserInputProfile stores the per-user profile, overriding the previous one
+func (s *Storage) SaveUserInputProfile(user string, profile InputProfile) error {
+	return saveInputProfile(userProfileDir, user, profile)
+}
+
+// SaveGameInputProfile stores the per-game profile, overriding the previous one
+func (s *Storage) SaveGameInputProfile(game string, profile InputProfile) error {
+	return saveInputProfile(gameProfileDir, game, profile)
+}
+
+func saveInputProfile(dir, name string, profile InputProfile) error {
+	path, err := profilePath(dir, name)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0644)
+}
+
+func loadInputProfile(path string) (InputProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return InputProfile{}, err
+	}
+
+	var profile InputProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return InputProfile{}, err
+	}
+
+	return profile, nil
+}
+
+// profilePath prevents name from escaping the profile directory
+func profilePath(dir, name string) (string, error) {
+	if !isValidName(name) {
+		return "", errors.New("invalid profile name")
+	}
+
+	return filepath.Join(profileDir, dir, name+profileFileSuffix), nil
+}
+
+// isValidName reports whether name can be used as a file name without leaving its directory
+func isValidName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
@@ -25,6 +25,7 @@ type (
 		Name          string
 		SupportedType map[string]interface{} // the game extension that Core supports
 		Path          string
+		MaxPlayers    uint // upper bound of players, the core may report fewer ports
 	}
 )
 
@@ -86,7 +87,8 @@ func (s *Storage) loadCoresMetadata() {
 		SupportedType: map[string]interface{}{
 			"zip": struct{}{}, "chd": struct{}{},
 		},
-		Path: filepath.Join(path, "mame2010_libretro.so"),
+		Path:       filepath.Join(path, "mame2010_libretro.so"),
+		MaxPlayers: 4,
 	})
 
 	res = append(res, CoreMeta{
@@ -94,7 +96,8 @@ func (s *Storage) loadCoresMetadata() {
 		SupportedType: map[string]interface{}{
 			"nes": struct{}{},
 		},
-		Path: filepath.Join(path, "nestopia_libretro.so"),
+		Path:       filepath.Join(path, "nestopia_libretro.so"),
+		MaxPlayers: 4, // four score
 	})
 
 	res = append(res, CoreMeta{
@@ -102,7 +105,8 @@ func (s *Storage) loadCoresMetadata() {
 		SupportedType: map[string]interface{}{
 			"sfc": struct{}{}, "smc": struct{}{},
 		},
-		Path: filepath.Join(path, "snes9x2010_libretro.so"),
+		Path:       filepath.Join(path, "snes9x2010_libretro.so"),
+		MaxPlayers: 5, // multitap
 	})
 
 	res = append(res, CoreMeta{
@@ -110,7 +114,8 @@ func (s *Storage) loadCoresMetadata() {
 		SupportedType: map[string]interface{}{
 			"gba": struct{}{},
 		},
-		Path: filepath.Join(path, "mednafen_gba_libretro.so"),
+		Path:       filepath.Join(path, "mednafen_gba_libretro.so"),
+		MaxPlayers: 1,
 	})
 
 	s.cores = res
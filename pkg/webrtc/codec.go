@@ -0,0 +1,91 @@
+package webrtc
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pion/sdp/v3"
+	"github.com/pion/webrtc/v3"
+)
+
+// VideoCodecs lists the video codecs of a client's session description, as lowercase names (e.g: "vp9"),
+// in the client's order of preference
+func VideoCodecs(sessionDescription string) ([]string, error) {
+	s := &sdp.SessionDescription{}
+	if err := s.UnmarshalString(sessionDescription); err != nil {
+		return nil, err
+	}
+
+	var codecs []string
+	for _, m := range s.MediaDescriptions {
+		if m.MediaName.Media != "video" {
+			continue
+		}
+
+		for _, format := range m.MediaName.Formats {
+			payloadType, err := strconv.ParseUint(format, 10, 8)
+			if err != nil {
+				continue
+			}
+
+			codec, err := s.GetCodecForPayloadType(uint8(payloadType))
+			if err != nil {
+				continue
+			}
+
+			name := strings.ToLower(codec.Name)
+			if !slices.Contains(codecs, name) {
+				codecs = append(codecs, name)
+			}
+		}
+	}
+	return codecs, nil
+}
+
+// SetVideoCodec replaces the video track by one of the given codec (e.g: "vp9") and makes it
+// the only video codec of the offer, it must be called before the offer is created
+func (pc *PeerConnection) SetVideoCodec(codec string) error {
+	mimeType := "video/" + strings.ToUpper(codec)
+	if err := pc.setVideoCodecPreferences(mimeType); err != nil {
+		return err
+	}
+
+	if pc.vTrack.Codec().MimeType == mimeType {
+		return nil
+	}
+
+	videoTrack, err := webrtc.NewTrackLocalStaticSample(
+		webrtc.RTPCodecCapability{MimeType: mimeType},
+		"video",
+		"video",
+	)
+	if err != nil {
+		return fmt.Errorf("create video track failed: %w", err)
+	}
+
+	if err := pc.vSender.ReplaceTrack(videoTrack); err != nil {
+		return fmt.Errorf("replace video track failed: %w", err)
+	}
+	pc.vTrack = videoTrack
+	return nil
+}
+
+// setVideoCodecPreferences keeps every registered variant of the codec, e.g: the H264 profiles
+func (pc *PeerConnection) setVideoCodecPreferences(mimeType string) error {
+	var codecs []webrtc.RTPCodecParameters
+	for _, c := range pc.vCodecs {
+		if strings.EqualFold(c.MimeType, mimeType) {
+			codecs = append(codecs, c)
+		}
+	}
+	if len(codecs) == 0 {
+		return fmt.Errorf("video codec %s is not registered", mimeType)
+	}
+
+	if err := pc.vTransceiver.SetCodecPreferences(codecs); err != nil {
+		return fmt.Errorf("set video codec preferences failed: %w", err)
+	}
+	return nil
+}
@@ -3,16 +3,29 @@ package webrtc
 import (
 	"net"
 
+	"github.com/pion/interceptor"
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/interceptor/pkg/gcc"
 	"github.com/pion/webrtc/v3"
 )
 
 type (
 	Factory struct {
 		*webrtc.API
+
+		// estimators receives the bandwidth estimator of each new peer connection
+		estimators chan cc.BandwidthEstimator
 	}
 )
 
-func NewFactory() (*Factory, error) {
+const (
+	INITIAL_BITRATE = 2000000
+	MIN_BITRATE     = 100000
+)
+
+// NewFactory registers the congestion controller (GCC over TWCC feedback),
+// maxBitrate bounds its estimate
+func NewFactory(maxBitrate int) (*Factory, error) {
 	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{Port: 9000})
 	if err != nil {
 		return nil, err
@@ -24,7 +37,48 @@ func NewFactory() (*Factory, error) {
 	m := &webrtc.MediaEngine{}
 	m.RegisterDefaultCodecs()
 
-	return &Factory{
-		API: webrtc.NewAPI(webrtc.WithSettingEngine(*s), webrtc.WithMediaEngine(m)),
-	}, nil
+	f := &Factory{
+		estimators: make(chan cc.BandwidthEstimator, 1),
+	}
+
+	i := &interceptor.Registry{}
+	congestionController, err := cc.NewInterceptor(func() (cc.BandwidthEstimator, error) {
+		return gcc.NewSendSideBWE(
+			gcc.SendSideBWEInitialBitrate(min(INITIAL_BITRATE, maxBitrate)),
+			gcc.SendSideBWEMinBitrate(MIN_BITRATE),
+			gcc.SendSideBWEMaxBitrate(maxBitrate),
+		)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	congestionController.OnNewPeerConnection(func(id string, estimator cc.BandwidthEstimator) {
+		select {
+		case f.estimators <- estimator:
+		default:
+		}
+	})
+	i.Add(congestionController)
+
+	if err := webrtc.ConfigureTWCCHeaderExtensionSender(m, i); err != nil {
+		return nil, err
+	}
+
+	if err := webrtc.RegisterDefaultInterceptors(m, i); err != nil {
+		return nil, err
+	}
+
+	f.API = webrtc.NewAPI(webrtc.WithSettingEngine(*s), webrtc.WithMediaEngine(m), webrtc.WithInterceptorRegistry(i))
+	return f, nil
+}
+
+// takeEstimator returns the estimator of the peer connection just created, nil if there is none
+func (f *Factory) takeEstimator() cc.BandwidthEstimator {
+	select {
+	case estimator := <-f.estimators:
+		return estimator
+	default:
+		return nil
+	}
 }
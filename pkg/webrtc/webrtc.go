@@ -5,9 +5,12 @@ import (
 	"cloud_gaming/pkg/message"
 	"encoding/json"
 	"fmt"
+	"sync/atomic"
 
 	_websocket "cloud_gaming/pkg/websocket"
 
+	"github.com/pion/interceptor/pkg/cc"
+	"github.com/pion/rtcp"
 	"github.com/pion/webrtc/v3"
 	"github.com/pion/webrtc/v3/pkg/media"
 	"go.uber.org/zap"
@@ -15,17 +18,32 @@ import (
 
 type (
 	PeerConnection struct {
+		// ID identifies the peer, e.g: to know which ports it owns, it is the id of its user connection
+		ID string
+
 		signalConn *_websocket.Conn
 		*webrtc.PeerConnection
 
-		vTrack *webrtc.TrackLocalStaticSample
-		aTrack *webrtc.TrackLocalStaticSample
+		vTrack  *webrtc.TrackLocalStaticSample
+		aTrack  *webrtc.TrackLocalStaticSample
+		vSender *webrtc.RTPSender
+		// vCodecs are all the video codecs the offer may list, vTransceiver is narrowed down to the chosen one
+		vCodecs      []webrtc.RTPCodecParameters
+		vTransceiver *webrtc.RTPTransceiver
+
+		rumbleChannel *webrtc.DataChannel
+
+		// estimator is the congestion controller's bandwidth estimate for this peer
+		estimator cc.BandwidthEstimator
+
+		// onKeyFrameRequest is called when the client asks for a keyframe (PLI/FIR)
+		onKeyFrameRequest atomic.Pointer[func()]
 	}
 )
 
-func NewPeerConnection(signalConn *_websocket.Conn, factory *Factory,
+func NewPeerConnection(id string, signalConn *_websocket.Conn, factory *Factory,
 	callbackWebRTCDisconnectedFunc func(),
-	keyboardCallback, mouseCallback func(msg webrtc.DataChannelMessage),
+	keyboardCallback, mouseCallback, analogCallback, gamepadCallback func(msg webrtc.DataChannelMessage),
 ) (*PeerConnection, error) {
 	peerConn, err := factory.NewPeerConnection(
 		webrtc.Configuration{
@@ -56,24 +74,36 @@ func NewPeerConnection(signalConn *_websocket.Conn, factory *Factory,
 			Label:   message.MSG_WEBRTC_ICE_CANDIDATE,
 			Payload: payload,
 			Error:   nil,
+			PeerID:  id,
 		})
 	})
 
+	pc := &PeerConnection{
+		ID:             id,
+		signalConn:     signalConn,
+		PeerConnection: peerConn,
+		estimator:      factory.takeEstimator(),
+	}
+
 	peerConn.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
 		log.Debug("state change", zap.String("state", state.String()))
 
-		if state == webrtc.PeerConnectionStateDisconnected {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			// the peer may join a running stream, it cannot decode anything before a keyframe
+			pc.requestKeyFrame()
+		case webrtc.PeerConnectionStateDisconnected:
 			log.Debug("webrtc disconnected")
 			callbackWebRTCDisconnectedFunc()
 		}
 	})
 
-	pc := &PeerConnection{
-		signalConn:     signalConn,
-		PeerConnection: peerConn,
+	if err := pc.addAVTrack(); err != nil {
+		pc.Close()
+		return nil, err
 	}
 
-	if err := pc.addAVTrack(); err != nil {
+	if err := pc.addOutputChannel(); err != nil {
 		pc.Close()
 		return nil, err
 	}
@@ -81,6 +111,8 @@ func NewPeerConnection(signalConn *_websocket.Conn, factory *Factory,
 	if err := pc.addInputChannel(
 		keyboardCallback,
 		mouseCallback,
+		analogCallback,
+		gamepadCallback,
 	); err != nil {
 		pc.Close()
 		return nil, err
@@ -115,12 +147,60 @@ func (pc *PeerConnection) addAVTrack() error {
 	}
 	pc.aTrack = audioTrack
 
-	pc.AddTrack(videoTrack)
-	pc.AddTrack(audioTrack)
+	videoSender, err := pc.AddTrack(videoTrack)
+	if err != nil {
+		return fmt.Errorf("add video track failed: %w", err)
+	}
+	pc.vSender = videoSender
+	pc.vCodecs = videoSender.GetParameters().Codecs
+	for _, t := range pc.GetTransceivers() {
+		if t.Sender() == videoSender {
+			pc.vTransceiver = t
+		}
+	}
+
+	audioSender, err := pc.AddTrack(audioTrack)
+	if err != nil {
+		return fmt.Errorf("add audio track failed: %w", err)
+	}
+
+	// RTCP has to be read for the interceptors (e.g: congestion control) to process it
+	go pc.readRTCP(videoSender, pc.handleVideoRTCP)
+	go pc.readRTCP(audioSender, nil)
 	return nil
 }
 
-func (pc *PeerConnection) addInputChannel(keyboardbCallback, mouseCallback func(msg webrtc.DataChannelMessage)) error {
+// readRTCP runs until the sender is stopped
+func (pc *PeerConnection) readRTCP(sender *webrtc.RTPSender, handle func([]rtcp.Packet)) {
+	for {
+		packets, _, err := sender.ReadRTCP()
+		if err != nil {
+			return
+		}
+
+		if handle != nil {
+			handle(packets)
+		}
+	}
+}
+
+func (pc *PeerConnection) handleVideoRTCP(packets []rtcp.Packet) {
+	for _, packet := range packets {
+		switch packet.(type) {
+		case *rtcp.PictureLossIndication, *rtcp.FullIntraRequest:
+			pc.requestKeyFrame()
+			return
+		}
+	}
+}
+
+func (pc *PeerConnection) requestKeyFrame() {
+	if f := pc.onKeyFrameRequest.Load(); f != nil {
+		(*f)()
+	}
+}
+
+func (pc *PeerConnection) addInputChannel(keyboardbCallback, mouseCallback, analogCallback, gamepadCallback func(msg webrtc.DataChannelMessage)) error {
 	kbChannel, err := pc.CreateDataChannel("keyboard", nil)
 	if err != nil {
 		return err
@@ -131,11 +211,67 @@ func (pc *PeerConnection) addInputChannel(keyboardbCallback, mouseCallback func(
 		return err
 	}
 
+	analogChannel, err := pc.CreateDataChannel("analog", nil)
+	if err != nil {
+		return err
+	}
+
+	// gamepad state is sent as a full snapshot every time,
+	// a lost or late packet is superseded by the next one so there is no need to retransmit or order them
+	ordered := false
+	maxRetransmits := uint16(0)
+	gamepadChannel, err := pc.CreateDataChannel("gamepad", &webrtc.DataChannelInit{
+		Ordered:        &ordered,
+		MaxRetransmits: &maxRetransmits,
+	})
+	if err != nil {
+		return err
+	}
+
 	kbChannel.OnMessage(keyboardbCallback)
 	mouseChannel.OnMessage(mouseCallback)
+	analogChannel.OnMessage(analogCallback)
+	gamepadChannel.OnMessage(gamepadCallback)
 	return nil
 }
 
+// addOutputChannel creates channels used to send data from worker to client
+func (pc *PeerConnection) addOutputChannel() error {
+	rumbleChannel, err := pc.CreateDataChannel("rumble", nil)
+	if err != nil {
+		return err
+	}
+
+	pc.rumbleChannel = rumbleChannel
+	return nil
+}
+
+func (pc *PeerConnection) SendRumble(data []byte) error {
+	if pc.rumbleChannel.ReadyState() != webrtc.DataChannelStateOpen {
+		return nil
+	}
+	return pc.rumbleChannel.Send(data)
+}
+
+// OnTargetBitrateChange is called whenever the bandwidth estimate of the peer changes, in bits per second
+func (pc *PeerConnection) OnTargetBitrateChange(f func(bitrate int)) {
+	if pc.estimator == nil {
+		return
+	}
+	pc.estimator.OnTargetBitrateChange(f)
+}
+
+func (pc *PeerConnection) GetTargetBitrate() int {
+	if pc.estimator == nil {
+		return 0
+	}
+	return pc.estimator.GetTargetBitrate()
+}
+
+func (pc *PeerConnection) OnKeyFrameRequest(f func()) {
+	pc.onKeyFrameRequest.Store(&f)
+}
+
 func (pc *PeerConnection) SendVideoFrame(sample media.Sample) error {
 	return pc.vTrack.WriteSample(sample)
 }
@@ -29,6 +29,13 @@ func (c *Conn) WriteJSON(v interface{}) error {
 	return c.Conn.WriteJSON(v)
 }
 
+// WriteMessage is locked like WriteJSON, e.g: the users of a session write to the same worker
+func (c *Conn) WriteMessage(messageType int, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Conn.WriteMessage(messageType, data)
+}
+
 func (c *Conn) SetConnectionStatus(isConnected bool) {
 	c.isConnected = isConnected
 }
@@ -8,12 +8,25 @@ import (
 )
 
 func (w *Worker) sendAudioPacket(audioPacket *audio.AudioPacket) {
-	w.peerConn.SendAudioFrame(media.Sample{
-		Data:     audioPacket.Buffer,
-		Duration: time.Duration(audioPacket.Duration) * time.Millisecond,
+	updateLatency(&w.avSync.audioLatency, w.emulator.MediaTime()-audioPacket.PTS)
+
+	nominal := time.Duration(audioPacket.Duration * float64(time.Millisecond))
+	// the encoder reuses its buffer and the packet may be held back, the copy goes back to the pool once sent
+	data := w.avSync.audioBuffers.Get(len(audioPacket.Buffer))
+	copy(data, audioPacket.Buffer)
+	w.avSync.audioLine.Push(media.Sample{
+		Data:     data,
+		Duration: w.avSync.audio.Duration(audioPacket.PTS, nominal),
 		Metadata: map[string]interface{}{
 			"Codec":  audioPacket.Codec,
 			"Format": audioPacket.Format,
 		},
-	})
+	}, w.avSync.AudioDelay())
+}
+
+// writeAudioSample runs on the audio delay line
+func (w *Worker) writeAudioSample(sample media.Sample) {
+	for _, p := range w.peers.All() {
+		p.SendAudioFrame(sample)
+	}
 }
@@ -0,0 +1,193 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/message"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/webrtc/v3/pkg/media"
+)
+
+type (
+	// rtpTimeline turns presentation times into sample durations, the RTP timestamp of a sample is the sum
+	// of the previous durations so each duration aims at the next pts. Presentation times come from the
+	// media clock of the emulator, shared by video and audio, so both tracks follow the same timeline
+	rtpTimeline struct {
+		mu   sync.Mutex
+		sent time.Duration // sum of the durations given to the track
+		base time.Duration // where the timeline of the running game starts on the track
+		last time.Duration
+	}
+
+	// delayLine sends the samples of a track in order, each one once its release time is reached
+	delayLine struct {
+		samples chan delayedSample
+		// the data of the samples goes back to buffers once written, nil if the caller owns it
+		buffers *bufferPool
+	}
+
+	// bufferPool recycles the data of samples so that copying a packet does not allocate, its zero value is empty
+	bufferPool struct {
+		mu   sync.Mutex
+		free [][]byte
+	}
+
+	delayedSample struct {
+		release time.Time
+		sample  media.Sample
+	}
+
+	// avSync measures how late each stream reaches WebRTC compared to the session timeline,
+	// the stream ahead is held back by the difference so that the client sees both in sync
+	avSync struct {
+		video rtpTimeline
+		audio rtpTimeline
+
+		videoLine delayLine
+		audioLine delayLine
+
+		// the audio encoder reuses its buffer, packets are copied in these while delayed
+		audioBuffers bufferPool
+
+		// smoothed, in ns
+		videoLatency atomic.Int64
+		audioLatency atomic.Int64
+	}
+
+	AVSyncResponse struct {
+		Offset       float64 `json:"offset_ms"` // positive when the video is behind the audio, after correction
+		Correction   float64 `json:"correction_ms"`
+		VideoLatency float64 `json:"video_latency_ms"`
+		AudioLatency float64 `json:"audio_latency_ms"`
+	}
+)
+
+const (
+	// the stream ahead is held back at most this long, a larger offset is not worth the latency
+	MAX_AV_SYNC_DELAY = 200 * time.Millisecond
+
+	// samples waiting to be sent on a track, way more than MAX_AV_SYNC_DELAY of video or audio
+	DELAY_LINE_SIZE = 64
+
+	// capacity of a pooled buffer, larger than most opus packets so that buffers fit any packet
+	SAMPLE_BUFFER_SIZE = 1500
+)
+
+// Duration returns the duration to give to the sample with pts, nominal is the expected time until the next sample
+func (t *rtpTimeline) Duration(pts, nominal time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// new game, the media clock restarted and the track's timeline goes on from where it is
+	if pts < t.last {
+		t.base = t.sent
+	}
+	t.last = pts
+
+	d := max(t.base+pts+nominal-t.sent, 0)
+	t.sent += d
+	return d
+}
+
+func (t *rtpTimeline) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sent = 0
+	t.base = 0
+	t.last = 0
+}
+
+// start runs the goroutine sending the samples of the line for the lifetime of the worker,
+// the data of each sample is handed back to buffers once sent if it is not nil
+func (l *delayLine) start(send func(media.Sample), buffers *bufferPool) {
+	l.samples = make(chan delayedSample, DELAY_LINE_SIZE)
+	l.buffers = buffers
+
+	go func() {
+		for s := range l.samples {
+			if d := time.Until(s.release); d > 0 {
+				time.Sleep(d)
+			}
+			send(s.sample)
+			if l.buffers != nil {
+				l.buffers.Put(s.sample.Data)
+			}
+		}
+	}()
+}
+
+// Push sends sample once delay has passed, the data of the sample must not be reused by the caller
+func (l *delayLine) Push(sample media.Sample, delay time.Duration) {
+	l.samples <- delayedSample{release: time.Now().Add(delay), sample: sample}
+}
+
+// Get returns a buffer of n bytes, its content is undefined
+func (p *bufferPool) Get(n int) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.free) > 0 {
+		b := p.free[len(p.free)-1]
+		p.free = p.free[:len(p.free)-1]
+		if cap(b) >= n {
+			return b[:n]
+		}
+	}
+	return make([]byte, n, max(n, SAMPLE_BUFFER_SIZE))
+}
+
+// Put hands b back, at most DELAY_LINE_SIZE buffers are kept which covers every sample a line holds
+func (p *bufferPool) Put(b []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.free) < DELAY_LINE_SIZE {
+		p.free = append(p.free, b)
+	}
+}
+
+// updateLatency follows RFC 3550 smoothing: L += (latency - L) / 16
+func updateLatency(l *atomic.Int64, latency time.Duration) {
+	prev := l.Load()
+	if prev == 0 {
+		l.Store(int64(latency))
+		return
+	}
+	l.Store(prev + (int64(latency)-prev)/16)
+}
+
+// syncDelay is how long a sample of the stream with latency own waits for the stream with latency other
+func syncDelay(own, other *atomic.Int64) time.Duration {
+	d := time.Duration(other.Load() - own.Load())
+	return min(max(d, 0), MAX_AV_SYNC_DELAY)
+}
+
+func (s *avSync) VideoDelay() time.Duration {
+	return syncDelay(&s.videoLatency, &s.audioLatency)
+}
+
+func (s *avSync) AudioDelay() time.Duration {
+	return syncDelay(&s.audioLatency, &s.videoLatency)
+}
+
+func (s *avSync) Reset() {
+	s.video.Reset()
+	s.audio.Reset()
+	s.videoLatency.Store(0)
+	s.audioLatency.Store(0)
+}
+
+func (w *Worker) sendAVSync(peer string) error {
+	video := time.Duration(w.avSync.videoLatency.Load())
+	audio := time.Duration(w.avSync.audioLatency.Load())
+	videoDelay, audioDelay := w.avSync.VideoDelay(), w.avSync.AudioDelay()
+
+	return w.sendJSONTo(peer, message.MSG_AV_SYNC, AVSyncResponse{
+		Offset:       float64((video+videoDelay)-(audio+audioDelay)) / float64(time.Millisecond),
+		Correction:   float64(audioDelay-videoDelay) / float64(time.Millisecond),
+		VideoLatency: float64(video) / float64(time.Millisecond),
+		AudioLatency: float64(audio) / float64(time.Millisecond),
+	})
+}
@@ -8,35 +8,62 @@ import (
 func (w *Worker) environmentCallback(cmd uint32, data unsafe.Pointer) bool {
 	switch cmd {
 	case libretro.ENVIRONMENT_SET_PIXEL_FORMAT:
-		w.videoPipe.SetPixelFormat(data)
-		return true
+		return w.videoPipe.SetPixelFormat(data)
 	case libretro.ENVIRONMENT_SET_ROTATION:
 		w.videoPipe.SetRotation(data)
 		return true
 	case libretro.EnvironmentGetLogInterface:
 		w.emulator.BindLogCallback(data, w.emulator.LogCallback)
 		return true
+	case libretro.EnvironmentGetRumbleInterface:
+		w.emulator.BindRumbleInterface(data, w.rumbleCallback)
+		return true
 	case libretro.EnvironmentGetSystemDirectory:
 		libretro.SetString(data, w.emulator.GetSystemDirectory())
 		return true
 	case libretro.EnvironmentGetVariableUpdate:
 		return false
+	case libretro.EnvironmentSetInputDescriptors:
+		w.inputDescriptors.Set(data)
+		return true
+	case libretro.EnvironmentSetControllerInfo:
+		w.controllerInfo.Set(data)
+		return true
+	case libretro.EnvironmentSetFrameTimeCallback:
+		w.emulator.SetFrameTimeCallback(data)
+		return true
+	case libretro.EnvironmentSetAudioCallback:
+		w.emulator.SetAudioCallback(data)
+		return true
+	case libretro.EnvironmentSetGeometry:
+		geometry := libretro.GetGeometry(data)
+		w.emulator.SetGeometry(geometry)
+		w.videoPipe.SetGeometry(geometry)
+		return true
+	case libretro.EnvironmentSetSystemAVInfo:
+		systemAVInfo := libretro.GetSystemAVInfo(data)
+		w.emulator.SetSystemAVInfo(systemAVInfo)
+		w.setSystemAVInfo(&systemAVInfo)
+		return true
+	case libretro.EnvironmentGetCurrentSoftwareFramebuffer:
+		return w.videoPipe.GetSoftwareFramebuffer(data)
 	case libretro.EnvironmentSetKeyboardCallback:
-		return false
+		w.emulator.SetKeyboardCallback(data)
+		return true
 	}
 	return false
 }
 
 func (w *Worker) videoRefreshCallback(data unsafe.Pointer, width int32, height int32, pitch int32) {
 	arr := unsafe.Slice((*byte)(data), pitch*height)
-	w.videoPipe.Process(arr, width, height, pitch)
+	w.videoPipe.Process(arr, width, height, pitch, w.emulator.FramePTS())
 }
 
 func (w *Worker) audioSampleCallback(l int16, r int16) {
-	w.audioPipe.Process([]int16{l, r}, 1)
+	w.audioPipe.Process([]int16{l, r}, 1, w.emulator.FramePTS())
 }
 
 func (w *Worker) audioSampleBatchCallback(buf unsafe.Pointer, frames int32) {
 	arr := unsafe.Slice((*int16)(buf), frames*2)
-	w.audioPipe.Process(arr, frames)
+	w.audioPipe.Process(arr, frames, w.emulator.FramePTS())
 }
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/encoder"
+	_video "cloud_gaming/pkg/ffmpeg/video"
+	_webrtc "cloud_gaming/pkg/webrtc"
+	"errors"
+	"slices"
+)
+
+type (
+	// WebRTCInitRequest optionally carries a session description of the client (e.g: an offer of a
+	// recvonly transceiver), listing the video codecs it can decode. H264 is used without it.
+	WebRTCInitRequest struct {
+		SDP string `json:"sdp"`
+	}
+)
+
+const (
+	DEFAULT_VIDEO_CODEC = "h264"
+)
+
+// negotiateVideoCodec picks the first codec of the server config the client supports and the server
+// has an encoder for, the video track and the encoder are switched to it before the offer is created.
+// The stream is shared, so a peer joining a session has to take the codec the others are receiving.
+func (w *Worker) negotiateVideoCodec(p *peer, r *WebRTCInitRequest) error {
+	codec := DEFAULT_VIDEO_CODEC
+	if w.peers.Len() > 1 {
+		codec = codecName(w.quality.Codec)
+		if r.SDP != "" {
+			clientCodecs, err := _webrtc.VideoCodecs(r.SDP)
+			if err != nil {
+				return err
+			}
+			if !slices.Contains(clientCodecs, codec) {
+				return errors.New("client does not support the video codec of the session")
+			}
+		}
+	} else if r.SDP != "" {
+		clientCodecs, err := _webrtc.VideoCodecs(r.SDP)
+		if err != nil {
+			return err
+		}
+
+		i := slices.IndexFunc(w.config.Video.Codecs, func(name string) bool {
+			c, ok := videoCodecs[name]
+			return ok && slices.Contains(clientCodecs, name) && w.hasVideoEncoder(c)
+		})
+		if i < 0 {
+			return errors.New("no video codec supported by both sides")
+		}
+		codec = w.config.Video.Codecs[i]
+	}
+
+	if err := p.SetVideoCodec(codec); err != nil {
+		return err
+	}
+
+	w.setVideoCodec(videoCodecs[codec])
+	return nil
+}
+
+func (w *Worker) hasVideoEncoder(codec _video.VideoCodec) bool {
+	return len(encoder.VideoEncoders(codec, w.config.Video.Encoders)) > 0
+}
+
+// setVideoCodec keeps the current quality, only the encoder changes
+func (w *Worker) setVideoCodec(codec _video.VideoCodec) {
+	w.quality.Codec = codec
+	w.videoPipe.SetQuality(w.quality)
+}
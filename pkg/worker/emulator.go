@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"cloud_gaming/pkg/emulator"
 	"cloud_gaming/pkg/libretro"
 	"cloud_gaming/pkg/log"
 	"errors"
@@ -10,12 +11,19 @@ import (
 
 type (
 	StartGameRequest struct {
-		Game string `json:"game"`
+		Game      string `json:"game"`
+		ScaleMode string `json:"scale_mode"` // fit (default), stretch or integer
 	}
 
 	StopGameRequest struct{}
 )
 
+var pacings = map[string]emulator.Pacing{
+	"":      emulator.PacingVideo,
+	"video": emulator.PacingVideo,
+	"audio": emulator.PacingAudio,
+}
+
 func (w *Worker) startEmulator(r *StartGameRequest) error {
 	if !w.emulator.IsReady() {
 		return errors.New("emulator is running")
@@ -27,6 +35,11 @@ func (w *Worker) startEmulator(r *StartGameRequest) error {
 		return err
 	}
 
+	scaleMode, ok := scaleModes[r.ScaleMode]
+	if !ok {
+		return errors.New("unknown scale mode")
+	}
+
 	coreMeta, err := w.storage.GetSuitableCore(gameMeta.FileType)
 	if err != nil {
 		log.Error("get core metadata failed", zap.Error(err))
@@ -52,18 +65,39 @@ func (w *Worker) startEmulator(r *StartGameRequest) error {
 		return err
 	}
 
+	w.game = r.Game
+	w.setupPorts(coreMeta.MaxPlayers)
+	w.applyInputProfiles()
+
+	w.videoPipe.SetScaleMode(scaleMode)
 	systemAVInfo := w.emulator.GetSystemAVInfo()
 	w.setSystemAVInfo(&systemAVInfo)
 
 	w.videoPipe.Start()
+	w.emulator.SetAudioCallbackInterval(w.audioPipe.PacketDuration())
 	w.emulator.StartGame()
+	w.startRumble()
+	if err := w.sendInputDescriptors(); err != nil {
+		log.Error("send input descriptors failed", zap.Error(err))
+	}
+	if err := w.sendControllerInfo(); err != nil {
+		log.Error("send controller info failed", zap.Error(err))
+	}
 	return nil
 }
 
+// stopEmulator frees the pipelines only after the emulator thread has exited,
+// the core may still be writing into the framebuffer until then.
+// Like startEmulator, it only runs on the request handler goroutine
 func (w *Worker) stopEmulator() {
 	w.emulator.StopGame()
+	w.stopRumble()
 	w.videoPipe.Close()
 	w.audioPipe.Close()
+	w.inputDescriptors.Reset()
+	w.controllerInfo.Reset()
+	w.game = ""
+	w.movieName = ""
 }
 
 func (w *Worker) setSystemAVInfo(systemAVInfo *libretro.SystemAVInfo) {
@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/message"
+	"time"
+)
+
+type (
+	FrameStatsResponse struct {
+		Frames    uint64  `json:"frames"`
+		Late      uint64  `json:"late"`
+		Jitter    float64 `json:"jitter_ms"`
+		MaxJitter float64 `json:"max_jitter_ms"`
+
+		// video frames the encoder could not keep up with, the game runs at full speed regardless
+		DroppedFrames uint64 `json:"dropped_frames"`
+		LateFrames    uint64 `json:"late_frames"`
+	}
+)
+
+func (w *Worker) sendFrameStats(peer string) error {
+	stats := w.emulator.GetFrameStats()
+	encoderStats := w.videoPipe.EncoderStats()
+
+	return w.sendJSONTo(peer, message.MSG_FRAME_STATS, FrameStatsResponse{
+		Frames:        stats.Frames,
+		Late:          stats.Late,
+		Jitter:        float64(stats.Jitter) / float64(time.Millisecond),
+		MaxJitter:     float64(stats.MaxJitter) / float64(time.Millisecond),
+		DroppedFrames: encoderStats.Dropped,
+		LateFrames:    encoderStats.Late,
+	})
+}
@@ -0,0 +1,116 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/libretro"
+	"cloud_gaming/pkg/log"
+	"encoding/binary"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+// Gamepad packet layout, little-endian, GAMEPAD_PACKET_SIZE bytes:
+//
+//	offset  size  field
+//	0       1     player
+//	1       4     sequence number, increased by 1 on every packet
+//	5       2     gamepad buttons, bit i is the state of button i in browser standard gamepad layout
+//	7       8     left x, left y, right x, right y as int16
+//	15      4     L2, R2 triggers as int16 in [0, 0x7fff]
+const (
+	GAMEPAD_PACKET_SIZE = 19
+)
+
+type (
+	gamepadPacket struct {
+		Player   uint8
+		Seq      uint32
+		Buttons  uint16
+		Axes     [4]int16
+		Triggers [2]int16
+	}
+
+	// gamepadSequence keeps the latest sequence number received from each player
+	// gamepad channel is unordered, so a packet older than the latest one is stale
+	gamepadSequence struct {
+		mu   sync.Mutex
+		last map[uint8]uint32
+	}
+)
+
+func decodeGamepadPacket(data []byte, pkt *gamepadPacket) bool {
+	if len(data) != GAMEPAD_PACKET_SIZE {
+		return false
+	}
+
+	pkt.Player = data[0]
+	pkt.Seq = binary.LittleEndian.Uint32(data[1:5])
+	pkt.Buttons = binary.LittleEndian.Uint16(data[5:7])
+	for i := range pkt.Axes {
+		pkt.Axes[i] = int16(binary.LittleEndian.Uint16(data[7+2*i:]))
+	}
+	for i := range pkt.Triggers {
+		pkt.Triggers[i] = int16(binary.LittleEndian.Uint16(data[15+2*i:]))
+	}
+
+	return true
+}
+
+// Accept reports whether seq is newer than the latest one of the player,
+// comparison is done on the signed difference so that it survives wraparound
+func (s *gamepadSequence) Accept(player uint8, seq uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.last == nil {
+		s.last = make(map[uint8]uint32)
+	}
+
+	if last, ok := s.last[player]; ok && int32(seq-last) <= 0 {
+		return false
+	}
+
+	s.last[player] = seq
+	return true
+}
+
+// Forget is called when the port changes hands, the new player starts its own sequence
+func (s *gamepadSequence) Forget(player uint8) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.last, player)
+}
+
+func (s *gamepadSequence) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.last = nil
+}
+
+func (w *Worker) handleGamepadChannel(peer string, msg webrtc.DataChannelMessage) {
+	var pkt gamepadPacket
+	if !decodeGamepadPacket(msg.Data, &pkt) {
+		log.Error("invalid gamepad packet", zap.Int("size", len(msg.Data)))
+		return
+	}
+
+	user := uint(pkt.Player)
+	if !w.ownsPort(peer, user) {
+		return
+	}
+
+	if !w.gamepadSeq.Accept(pkt.Player, pkt.Seq) {
+		return
+	}
+
+	w.emulator.SetGamepadButtons(user, pkt.Buttons)
+
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogLeft), uint(libretro.DeviceIDAnalogX), int32(pkt.Axes[0]))
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogLeft), uint(libretro.DeviceIDAnalogY), int32(pkt.Axes[1]))
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogRight), uint(libretro.DeviceIDAnalogX), int32(pkt.Axes[2]))
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogRight), uint(libretro.DeviceIDAnalogY), int32(pkt.Axes[3]))
+
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogButton), uint(libretro.DeviceIDJoypadL2), int32(pkt.Triggers[0]))
+	w.emulator.SetAnalogState(user, uint(libretro.DeviceIndexAnalogButton), uint(libretro.DeviceIDJoypadR2), int32(pkt.Triggers[1]))
+}
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"cloud_gaming/pkg/emulator"
 	"cloud_gaming/pkg/log"
 	"encoding/json"
 
@@ -14,21 +15,47 @@ type (
 		ButtonState []buttonState `json:"button_state"`
 	}
 
+	// buttonState identifies the key either by browser's KeyboardEvent.code
+	// or, when code is empty, directly by its retro_key in button
 	buttonState struct {
-		Button  uint `json:"button"`
-		Pressed bool `json:"pressed"`
+		Button  uint   `json:"button"`
+		Code    string `json:"code"`
+		Key     string `json:"key"` // browser's KeyboardEvent.key, gives the produced character
+		Pressed bool   `json:"pressed"`
+	}
+
+	analogData struct {
+		User uint        `json:"user"`
+		Axes []axisState `json:"axes"`
+	}
+
+	// axisState follows libretro's analog device,
+	// index: left stick, right stick or analog button; id: x, y axis or retropad button
+	axisState struct {
+		Index uint  `json:"index"`
+		ID    uint  `json:"id"`
+		Value int32 `json:"value"`
 	}
 
-	// currently not used
 	mouseData struct {
-		User   uint `json:"user"`
-		Button int  `json:"button"` // left, middle, right
-		PosX   int  `json:"pos_x"`
-		PosY   int  `json:"pox_y"`
+		User    uint   `json:"user"`
+		Buttons uint32 `json:"buttons"` // same bits as browser's MouseEvent.buttons
+
+		// relative movement since the previous message
+		DX int32 `json:"dx"`
+		DY int32 `json:"dy"`
+
+		// wheel notches since the previous message, negative means up/left
+		Wheel  int32 `json:"wheel"`
+		HWheel int32 `json:"hwheel"`
+
+		// absolute position in the output resolution, used by lightgun and pointer
+		PosX int `json:"pos_x"`
+		PosY int `json:"pos_y"`
 	}
 )
 
-func (w *Worker) handleKeyboardChannel(msg webrtc.DataChannelMessage) {
+func (w *Worker) handleKeyboardChannel(peer string, msg webrtc.DataChannelMessage) {
 	var kb = &keyboardData{}
 	err := json.Unmarshal(msg.Data, kb)
 	if err != nil {
@@ -37,12 +64,44 @@ func (w *Worker) handleKeyboardChannel(msg webrtc.DataChannelMessage) {
 	}
 
 	user := kb.User
+	if !w.ownsPort(peer, user) {
+		return
+	}
+
 	for _, bt := range kb.ButtonState {
-		w.emulator.SetKeyboardState(user, bt.Button, bt.Pressed)
+		id := bt.Button
+		if bt.Code != "" {
+			id = emulator.BrowserCodeToRetroKey(bt.Code)
+		}
+
+		var character uint32
+		if bt.Pressed {
+			character = emulator.BrowserKeyToCharacter(bt.Key)
+		}
+
+		w.emulator.SetKeyboardState(user, id, character, bt.Pressed)
+	}
+}
+
+func (w *Worker) handleAnalogChannel(peer string, msg webrtc.DataChannelMessage) {
+	var analog = &analogData{}
+	err := json.Unmarshal(msg.Data, analog)
+	if err != nil {
+		log.Error("unmarshal analog data failed", zap.Error(err))
+		return
+	}
+
+	user := analog.User
+	if !w.ownsPort(peer, user) {
+		return
+	}
+
+	for _, axis := range analog.Axes {
+		w.emulator.SetAnalogState(user, axis.Index, axis.ID, axis.Value)
 	}
 }
 
-func (w *Worker) handleMouseChannel(msg webrtc.DataChannelMessage) {
+func (w *Worker) handleMouseChannel(peer string, msg webrtc.DataChannelMessage) {
 	var mouse = &mouseData{}
 	err := json.Unmarshal(msg.Data, mouse)
 	if err != nil {
@@ -51,6 +110,14 @@ func (w *Worker) handleMouseChannel(msg webrtc.DataChannelMessage) {
 	}
 
 	user := mouse.User
-	w.emulator.SetMouseState(user, uint(mouse.Button))
-	w.emulator.SetMousePos(user, mouse.PosX, mouse.PosY)
+	if !w.ownsPort(peer, user) {
+		return
+	}
+
+	w.emulator.SetMouseButtons(user, mouse.Buttons)
+	w.emulator.MoveMouse(user, mouse.DX, mouse.DY)
+	w.emulator.ScrollMouse(user, mouse.Wheel, mouse.HWheel)
+
+	x, y, offscreen := w.videoPipe.ToScreenPos(mouse.PosX, mouse.PosY)
+	w.emulator.SetPointerPos(user, x, y, offscreen)
 }
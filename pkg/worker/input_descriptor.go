@@ -0,0 +1,60 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/libretro"
+	"cloud_gaming/pkg/message"
+	"sync"
+	"unsafe"
+)
+
+type (
+	// inputDescriptors keeps the button labels announced by the core,
+	// e.g: port 0, joypad, B -> "Jump"
+	inputDescriptors struct {
+		mu          sync.Mutex
+		descriptors []inputDescriptor
+	}
+
+	inputDescriptor struct {
+		Port        uint   `json:"port"`
+		Device      uint32 `json:"device"`
+		Index       uint   `json:"index"`
+		ID          uint   `json:"id"`
+		Description string `json:"description"`
+	}
+)
+
+func (d *inputDescriptors) Set(data unsafe.Pointer) {
+	descriptors := libretro.GetInputDescriptors(data)
+	res := make([]inputDescriptor, 0, len(descriptors))
+
+	for _, desc := range descriptors {
+		res = append(res, inputDescriptor{
+			Port:        desc.Port,
+			Device:      desc.Device,
+			Index:       desc.Index,
+			ID:          desc.ID,
+			Description: desc.Description,
+		})
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.descriptors = res
+}
+
+func (d *inputDescriptors) Get() []inputDescriptor {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.descriptors
+}
+
+func (d *inputDescriptors) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.descriptors = nil
+}
+
+func (w *Worker) sendInputDescriptors() error {
+	return w.sendJSON(message.MSG_INPUT_DESCRIPTORS, w.inputDescriptors.Get())
+}
@@ -0,0 +1,110 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/emulator"
+	"cloud_gaming/pkg/message"
+	"errors"
+)
+
+type (
+	StartRecordingRequest struct {
+		Name      string `json:"name"`
+		FromState bool   `json:"from_state"` // start from the current state instead of power-on
+	}
+
+	StartPlaybackRequest struct {
+		Name string `json:"name"`
+		Loop bool   `json:"loop"`
+	}
+
+	MovieStatusResponse struct {
+		Mode   string `json:"mode"`
+		Name   string `json:"name"`
+		Frame  uint32 `json:"frame"`
+		Frames uint32 `json:"frames"`
+	}
+)
+
+var movieModeNames = map[emulator.MovieMode]string{
+	emulator.MovieNone:      "none",
+	emulator.MovieRecording: "recording",
+	emulator.MoviePlayback:  "playback",
+}
+
+func (w *Worker) startRecording(r *StartRecordingRequest) error {
+	if w.game == "" {
+		return errors.New("no game is running")
+	}
+
+	// the movie is only saved once recorded, a bad name would lose it
+	if err := w.storage.ValidateMovieName(w.game, r.Name); err != nil {
+		return err
+	}
+
+	if err := w.emulator.StartRecording(r.FromState); err != nil {
+		return err
+	}
+
+	w.movieName = r.Name
+	return w.sendMovieStatus()
+}
+
+// stopRecording saves the recorded movie alongside the game
+func (w *Worker) stopRecording() error {
+	movie, err := w.emulator.StopRecording()
+	if err != nil {
+		return err
+	}
+
+	name := w.movieName
+	w.movieName = ""
+	if err := w.storage.SaveMovie(w.game, name, movie.Encode()); err != nil {
+		return err
+	}
+
+	return w.sendMovieStatus()
+}
+
+func (w *Worker) startPlayback(r *StartPlaybackRequest) error {
+	if w.game == "" {
+		return errors.New("no game is running")
+	}
+
+	data, err := w.storage.LoadMovie(w.game, r.Name)
+	if err != nil {
+		return err
+	}
+
+	movie, err := emulator.DecodeMovie(data)
+	if err != nil {
+		return err
+	}
+
+	if err := w.emulator.StartPlayback(movie, r.Loop); err != nil {
+		return err
+	}
+
+	w.movieName = r.Name
+	return w.sendMovieStatus()
+}
+
+func (w *Worker) stopPlayback() error {
+	w.emulator.StopPlayback()
+	w.movieName = ""
+	return w.sendMovieStatus()
+}
+
+func (w *Worker) sendMovieStatus() error {
+	status := w.emulator.GetMovieStatus()
+
+	res := MovieStatusResponse{
+		Mode:   movieModeNames[status.Mode],
+		Frame:  status.Frame,
+		Frames: status.Frames,
+	}
+	if status.Mode != emulator.MovieNone {
+		res.Name = w.movieName
+	}
+
+	return w.sendJSON(message.MSG_MOVIE_STATUS, res)
+}
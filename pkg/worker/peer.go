@@ -0,0 +1,159 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/log"
+	_webrtc "cloud_gaming/pkg/webrtc"
+	"sync"
+
+	"github.com/pion/webrtc/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	// ALL_PEERS sends a response to every peer of the session
+	ALL_PEERS = ""
+
+	// disconnected peers waiting for the request handler, more block pion's callback
+	MAX_PENDING_DISCONNECTS = 8
+)
+
+type (
+	// peer is a user connected to the session, it is identified by the id of its user connection
+	peer struct {
+		*_webrtc.PeerConnection
+
+		// user is the identity the peer joined with, its input profiles are saved under it
+		user string
+		// estimate is the latest bandwidth estimate of the peer, 0 if unknown
+		estimate int
+	}
+
+	// peerSet keeps the peers of the session, the encoded stream is shared by all of them
+	peerSet struct {
+		mu    sync.Mutex
+		peers map[string]*peer
+	}
+)
+
+func (s *peerSet) Get(id string) *peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peers[id]
+}
+
+// Add returns the peer replaced by p, e.g: when the client re-initializes its connection
+func (s *peerSet) Add(p *peer) *peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peers == nil {
+		s.peers = make(map[string]*peer)
+	}
+
+	old := s.peers[p.ID]
+	s.peers[p.ID] = p
+	return old
+}
+
+// Remove only removes p if it is still the peer of its id
+func (s *peerSet) Remove(p *peer) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.peers[p.ID] != p {
+		return false
+	}
+	delete(s.peers, p.ID)
+	return true
+}
+
+func (s *peerSet) All() []*peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]*peer, 0, len(s.peers))
+	for _, p := range s.peers {
+		res = append(res, p)
+	}
+	return res
+}
+
+func (s *peerSet) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.peers)
+}
+
+// SetEstimate returns the lowest estimate of the session, the stream has to fit the slowest peer
+func (s *peerSet) SetEstimate(p *peer, bitrate int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p.estimate = bitrate
+
+	lowest := 0
+	for _, other := range s.peers {
+		if other.estimate > 0 && (lowest == 0 || other.estimate < lowest) {
+			lowest = other.estimate
+		}
+	}
+	return lowest
+}
+
+// newPeer replaces the previous connection of the peer id, if any
+func (w *Worker) newPeer(id string) (*peer, error) {
+	p := &peer{}
+
+	peerConn, err := _webrtc.NewPeerConnection(id, w.coordinatorConn, w.webrtcFactory,
+		func() { w.disconnected <- p },
+		func(msg webrtc.DataChannelMessage) { w.handleKeyboardChannel(id, msg) },
+		func(msg webrtc.DataChannelMessage) { w.handleMouseChannel(id, msg) },
+		func(msg webrtc.DataChannelMessage) { w.handleAnalogChannel(id, msg) },
+		func(msg webrtc.DataChannelMessage) { w.handleGamepadChannel(id, msg) },
+	)
+	if err != nil {
+		return nil, err
+	}
+	p.PeerConnection = peerConn
+
+	peerConn.OnTargetBitrateChange(func(bitrate int) {
+		w.videoPipe.SetTargetBitrate(w.peers.SetEstimate(p, bitrate))
+	})
+	peerConn.OnKeyFrameRequest(w.videoPipe.RequestKeyFrame)
+
+	if old := w.peers.Add(p); old != nil {
+		old.Close()
+	}
+	return p, nil
+}
+
+// removePeer runs on the request handler goroutine when the peer disconnects or its user leaves
+// the session, the game stops with the last peer
+func (w *Worker) removePeer(p *peer) {
+	if !w.peers.Remove(p) {
+		return
+	}
+	p.Close()
+
+	for _, port := range w.ports.ReleaseAll(p.ID) {
+		w.gamepadSeq.Forget(uint8(port))
+	}
+
+	if w.peers.Len() > 0 {
+		w.videoPipe.SetTargetBitrate(w.peers.SetEstimate(p, 0))
+		if err := w.sendControllerInfo(); err != nil {
+			log.Error("send controller info failed", zap.Error(err))
+		}
+		return
+	}
+
+	w.stopEmulator()
+	w.avSync.Reset()
+}
+
+// removePeerByID is called when the coordinator reports that the user of the peer left
+func (w *Worker) removePeerByID(id string) {
+	if p := w.peers.Get(id); p != nil {
+		w.removePeer(p)
+	}
+}
@@ -0,0 +1,293 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/emulator"
+	"cloud_gaming/pkg/libretro"
+	"cloud_gaming/pkg/log"
+	"cloud_gaming/pkg/message"
+	"encoding/json"
+	"errors"
+	"sync"
+	"unsafe"
+
+	"go.uber.org/zap"
+)
+
+const (
+	ANY_PORT = -1
+)
+
+type (
+	// controllerInfo keeps the devices supported by each port, announced by the core
+	controllerInfo struct {
+		mu    sync.Mutex
+		ports [][]libretro.ControllerDescription
+	}
+
+	// portAssignment keeps which peer plays on which port, a peer may own several ports,
+	// e.g: two gamepads on the same computer
+	portAssignment struct {
+		mu     sync.Mutex
+		owners [emulator.MAX_PLAYERS]string
+	}
+
+	AssignPortRequest struct {
+		Port int `json:"port"` // ANY_PORT to take the first free port, even if the peer already owns one
+	}
+
+	ReleasePortRequest struct {
+		Port uint `json:"port"`
+	}
+
+	SetControllerDeviceRequest struct {
+		Port   uint   `json:"port"`
+		Device uint32 `json:"device"`
+	}
+
+	PortAssignedResponse struct {
+		Port uint `json:"port"`
+	}
+
+	ControllerInfoResponse struct {
+		Ports []controllerPort `json:"ports"`
+	}
+
+	controllerPort struct {
+		Port    uint               `json:"port"`
+		Device  uint32             `json:"device"` // current device
+		Devices []controllerDevice `json:"devices"`
+		Owned   bool               `json:"owned"` // port is assigned to the receiver
+	}
+
+	controllerDevice struct {
+		ID   uint32 `json:"id"`
+		Desc string `json:"desc"`
+	}
+)
+
+func (c *controllerInfo) Set(data unsafe.Pointer) {
+	ports := libretro.GetControllerInfo(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ports = ports
+}
+
+func (c *controllerInfo) Get() [][]libretro.ControllerDescription {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ports
+}
+
+func (c *controllerInfo) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ports = nil
+}
+
+// Assign gives port to peer, or the first free port among the first numPorts when port is ANY_PORT
+func (p *portAssignment) Assign(peer string, port int, numPorts uint) (uint, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	numPorts = min(numPorts, emulator.MAX_PLAYERS)
+
+	if port == ANY_PORT {
+		for i := uint(0); i < numPorts; i++ {
+			if p.owners[i] == "" {
+				p.owners[i] = peer
+				return i, nil
+			}
+		}
+		return 0, errors.New("no free port")
+	}
+
+	if port < 0 || uint(port) >= numPorts {
+		return 0, errors.New("invalid port")
+	}
+
+	if p.owners[port] != "" && p.owners[port] != peer {
+		return 0, errors.New("port is taken")
+	}
+
+	p.owners[port] = peer
+	return uint(port), nil
+}
+
+func (p *portAssignment) Release(peer string, port uint) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if port < emulator.MAX_PLAYERS && p.owners[port] == peer {
+		p.owners[port] = ""
+	}
+}
+
+// ReleaseAll releases the ports of peer and returns them
+func (p *portAssignment) ReleaseAll(peer string) []uint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []uint
+	for port, owner := range p.owners {
+		if owner == peer {
+			p.owners[port] = ""
+			res = append(res, uint(port))
+		}
+	}
+	return res
+}
+
+// Owner returns the peer playing on port, empty if the port is free
+func (p *portAssignment) Owner(port uint) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if port >= emulator.MAX_PLAYERS {
+		return ""
+	}
+	return p.owners[port]
+}
+
+func (p *portAssignment) IsOwner(peer string, port uint) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return port < emulator.MAX_PLAYERS && p.owners[port] == peer
+}
+
+func (p *portAssignment) OwnedBy(peer string) []uint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []uint
+	for port, owner := range p.owners {
+		if owner == peer {
+			res = append(res, uint(port))
+		}
+	}
+	return res
+}
+
+// ownsPort reports whether the peer is allowed to send input for port
+func (w *Worker) ownsPort(peer string, port uint) bool {
+	return w.ports.IsOwner(peer, port)
+}
+
+// setupPorts sets the number of players from the core and its config, e.g: 5 for snes9x with a multitap,
+// the core may announce fewer ports in its controller info when the multitap is not plugged in yet
+func (w *Worker) setupPorts(maxPlayers uint) {
+	numPlayers := uint(emulator.DEFAULT_PLAYERS)
+	if maxPlayers > 0 {
+		numPlayers = maxPlayers
+	}
+	w.emulator.SetNumPlayers(numPlayers)
+
+	for _, p := range w.peers.All() {
+		w.ensurePort(p.ID)
+	}
+}
+
+// ensurePort gives a free port to the peer if it has none, peers joining a full game only watch
+func (w *Worker) ensurePort(peer string) {
+	if len(w.ports.OwnedBy(peer)) > 0 {
+		return
+	}
+
+	port, err := w.ports.Assign(peer, ANY_PORT, w.emulator.GetNumPlayers())
+	if err != nil {
+		log.Info("no port for peer", zap.String("peer", peer), zap.Error(err))
+		return
+	}
+	w.gamepadSeq.Forget(uint8(port))
+	w.applyInputProfile(peer, port)
+}
+
+func (w *Worker) assignPort(peer string, r *AssignPortRequest) error {
+	port, err := w.ports.Assign(peer, r.Port, w.emulator.GetNumPlayers())
+	if err != nil {
+		return err
+	}
+	w.gamepadSeq.Forget(uint8(port))
+	w.applyInputProfile(peer, port)
+
+	if err := w.sendJSONTo(peer, message.MSG_PORT_ASSIGNED, PortAssignedResponse{Port: port}); err != nil {
+		return err
+	}
+	return w.sendControllerInfo()
+}
+
+func (w *Worker) releasePort(peer string, r *ReleasePortRequest) error {
+	w.ports.Release(peer, r.Port)
+	return w.sendControllerInfo()
+}
+
+func (w *Worker) setControllerDevice(peer string, r *SetControllerDeviceRequest) error {
+	if !w.ownsPort(peer, r.Port) {
+		return errors.New("port is not assigned to peer")
+	}
+
+	if err := w.emulator.SetControllerPortDevice(r.Port, r.Device); err != nil {
+		return err
+	}
+
+	return w.sendControllerInfo()
+}
+
+// sendControllerInfo sends every peer its own view of the ports, Owned differs between them
+func (w *Worker) sendControllerInfo() error {
+	var errs []error
+	for _, p := range w.peers.All() {
+		errs = append(errs, w.sendControllerInfoTo(p.ID))
+	}
+	return errors.Join(errs...)
+}
+
+func (w *Worker) sendControllerInfoTo(peer string) error {
+	info := w.controllerInfo.Get()
+	numPlayers := w.emulator.GetNumPlayers()
+
+	res := ControllerInfoResponse{
+		Ports: make([]controllerPort, 0, numPlayers),
+	}
+
+	for port := uint(0); port < numPlayers; port++ {
+		cp := controllerPort{
+			Port:   port,
+			Device: w.emulator.GetControllerPortDevice(port),
+			Owned:  w.ownsPort(peer, port),
+		}
+
+		if port < uint(len(info)) {
+			for _, desc := range info[port] {
+				cp.Devices = append(cp.Devices, controllerDevice{
+					ID:   desc.ID,
+					Desc: desc.Desc,
+				})
+			}
+		}
+
+		res.Ports = append(res.Ports, cp)
+	}
+
+	return w.sendJSONTo(peer, message.MSG_CONTROLLER_INFO, res)
+}
+
+// sendJSON sends v to every peer of the session
+func (w *Worker) sendJSON(label message.MsgType, v interface{}) error {
+	return w.sendJSONTo(ALL_PEERS, label, v)
+}
+
+func (w *Worker) sendJSONTo(peer string, label message.MsgType, v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		log.Error("marshal payload failed", zap.String("label", string(label)), zap.Error(err))
+		return err
+	}
+
+	return w.coordinatorConn.WriteJSON(message.ResponseMsg{
+		Label:   label,
+		Payload: payload,
+		Error:   nil,
+		PeerID:  peer,
+	})
+}
@@ -0,0 +1,134 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/emulator"
+	"cloud_gaming/pkg/log"
+	"cloud_gaming/pkg/message"
+	"cloud_gaming/pkg/storage"
+	"errors"
+
+	"go.uber.org/zap"
+)
+
+const (
+	PROFILE_SCOPE_USER = "user"
+	PROFILE_SCOPE_GAME = "game"
+)
+
+type (
+	// profiles are read and saved under the user the coordinator identified the peer as,
+	// game profiles are shared by everyone so only the owner of the session saves them
+	GetInputProfileRequest struct {
+		Port uint `json:"port"`
+	}
+
+	UpdateInputProfileRequest struct {
+		Port    uint                 `json:"port"`
+		Scope   string               `json:"scope"` // user (default) or game
+		Profile storage.InputProfile `json:"profile"`
+	}
+
+	InputProfileResponse struct {
+		Port    uint                 `json:"port"`
+		Profile storage.InputProfile `json:"profile"`
+	}
+)
+
+// resolveInputMapping layers default, per-game and per-user profiles on top of the built-in mapping
+func (w *Worker) resolveInputMapping(user, game string) (*emulator.InputMapping, error) {
+	profiles, err := w.storage.GetInputProfiles(user, game)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := emulator.DefaultInputMapping()
+	for _, profile := range profiles {
+		mapping = mapping.Merge(&emulator.InputMapping{
+			Keyboard: profile.Keyboard,
+			Gamepad:  profile.Gamepad,
+		})
+	}
+
+	return mapping, nil
+}
+
+// peerUser returns the identity the peer joined with, empty for unknown peers
+func (w *Worker) peerUser(peer string) string {
+	if p := w.peers.Get(peer); p != nil {
+		return p.user
+	}
+	return ""
+}
+
+// applyInputProfile sets the mapping of the peer's user on port
+func (w *Worker) applyInputProfile(peer string, port uint) {
+	mapping, err := w.resolveInputMapping(w.peerUser(peer), w.game)
+	if err != nil {
+		log.Error("apply input profile failed", zap.Error(err))
+		return
+	}
+
+	w.emulator.SetInputMapping(port, mapping)
+}
+
+// applyInputProfiles sets the mapping of each peer on its ports,
+// it is called when the game starts and whenever a profile is updated, so changes apply right away
+func (w *Worker) applyInputProfiles() {
+	for _, p := range w.peers.All() {
+		for _, port := range w.ports.OwnedBy(p.ID) {
+			w.applyInputProfile(p.ID, port)
+		}
+	}
+}
+
+func (w *Worker) getInputProfile(peer string, r *GetInputProfileRequest) error {
+	mapping, err := w.resolveInputMapping(w.peerUser(peer), w.game)
+	if err != nil {
+		return err
+	}
+
+	return w.sendInputProfile(peer, r.Port, mapping)
+}
+
+func (w *Worker) updateInputProfile(peer string, owner bool, r *UpdateInputProfileRequest) error {
+	if !w.ownsPort(peer, r.Port) {
+		return errors.New("port is not assigned to peer")
+	}
+
+	var err error
+	switch r.Scope {
+	case "", PROFILE_SCOPE_USER:
+		user := w.peerUser(peer)
+		if user == "" {
+			return errors.New("no user in session")
+		}
+		err = w.storage.SaveUserInputProfile(user, r.Profile)
+	case PROFILE_SCOPE_GAME:
+		if !owner {
+			return errors.New("only the session owner saves game profiles")
+		}
+		if w.game == "" {
+			return errors.New("no game is running")
+		}
+		err = w.storage.SaveGameInputProfile(w.game, r.Profile)
+	default:
+		return errors.New("unknown profile scope")
+	}
+	if err != nil {
+		return err
+	}
+
+	// a game profile changes the mapping of every peer
+	w.applyInputProfiles()
+	return w.getInputProfile(peer, &GetInputProfileRequest{Port: r.Port})
+}
+
+func (w *Worker) sendInputProfile(peer string, port uint, mapping *emulator.InputMapping) error {
+	return w.sendJSONTo(peer, message.MSG_INPUT_PROFILE, InputProfileResponse{
+		Port: port,
+		Profile: storage.InputProfile{
+			Keyboard: mapping.Keyboard,
+			Gamepad:  mapping.Gamepad,
+		},
+	})
+}
@@ -0,0 +1,243 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/encoder"
+	_video "cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/message"
+	"cloud_gaming/pkg/pipeline/audio"
+	"cloud_gaming/pkg/pipeline/video"
+	"errors"
+	"slices"
+	"time"
+)
+
+type (
+	// SetQualityRequest selects a preset, the video fields are only used by the custom preset
+	SetQualityRequest struct {
+		Preset  string `json:"preset"`
+		Width   int    `json:"width"`
+		Height  int    `json:"height"`
+		Bitrate int    `json:"bitrate"`
+		CRF     int    `json:"crf"`
+		Codec   string `json:"codec"`
+		// AudioProfile applies to any preset, the current one is kept when empty
+		AudioProfile string `json:"audio_profile"`
+		// the custom audio profile tunes the standard one with these fields
+		AudioFrameDuration float64 `json:"audio_frame_duration"` // in milliseconds: 2.5, 5, 10, 20, 40 or 60
+		AudioComplexity    int     `json:"audio_complexity"`     // 0-10
+		AudioPacketLoss    int     `json:"audio_packet_loss"`    // expected loss in percent
+	}
+
+	QualityResponse struct {
+		Preset  string `json:"preset"`
+		Width   int    `json:"width"` // 0 means that it follows the game geometry
+		Height  int    `json:"height"`
+		Bitrate int    `json:"bitrate"`
+		CRF     int    `json:"crf"`
+		Codec   string `json:"codec"`
+
+		AudioProfile string `json:"audio_profile"`
+	}
+)
+
+const (
+	PRESET_AUTO   = "auto"
+	PRESET_CUSTOM = "custom"
+
+	MAX_CRF = 51
+
+	AUDIO_PROFILE_STANDARD = "standard"
+	AUDIO_PROFILE_CUSTOM   = "custom"
+)
+
+var qualityPresets = map[string]video.Quality{
+	PRESET_AUTO: video.DefaultQuality(),
+	"480p": {
+		Width:   854,
+		Height:  480,
+		Bitrate: 1000000,
+		CRF:     28,
+	},
+	"720p": {
+		Width:   1280,
+		Height:  720,
+		Bitrate: 2500000,
+		CRF:     23,
+	},
+	"1080p": {
+		Width:   1920,
+		Height:  1080,
+		Bitrate: 5000000,
+		CRF:     21,
+	},
+}
+
+var audioProfiles = map[string]audio.Profile{
+	// mono voice-grade audio for bad links
+	"low": {
+		Channels:      1,
+		Application:   encoder.OpusAppVoIP,
+		Bitrate:       24000,
+		FrameDuration: 20 * time.Millisecond,
+		Complexity:    5,
+		PacketLoss:    10,
+		MaxBandwidth:  encoder.OpusWideband,
+		FEC:           true,
+		DTX:           true,
+	},
+	AUDIO_PROFILE_STANDARD: audio.DefaultProfile(),
+	// fidelity over latency, e.g: for soundtracks
+	"music": {
+		Channels:      2,
+		Application:   encoder.OpusAppAudio,
+		Bitrate:       160000,
+		FrameDuration: 20 * time.Millisecond,
+		Complexity:    10,
+		MaxBandwidth:  encoder.OpusFullband,
+	},
+}
+
+var videoCodecs = map[string]_video.VideoCodec{
+	"h264": _video.H264,
+	"vp8":  _video.VP8,
+	"vp9":  _video.VP9,
+	"av1":  _video.AV1,
+}
+
+// setQuality is accepted before and during a game, the pipeline applies it before its next frame
+func (w *Worker) setQuality(r *SetQualityRequest) error {
+	preset := r.Preset
+	if preset == "" {
+		preset = PRESET_AUTO
+	}
+
+	var quality video.Quality
+	if preset == PRESET_CUSTOM {
+		c := w.quality.Codec
+		if r.Codec != "" {
+			var ok bool
+			if c, ok = videoCodecs[r.Codec]; !ok {
+				return errors.New("unknown codec")
+			}
+		}
+
+		// the video track only accepts the codec negotiated with the client
+		if c != w.quality.Codec {
+			return errors.New("codec not negotiated")
+		}
+
+		quality = video.Quality{
+			Width:   r.Width,
+			Height:  r.Height,
+			Bitrate: r.Bitrate,
+			CRF:     r.CRF,
+			Codec:   c,
+		}
+	} else {
+		var ok bool
+		if quality, ok = qualityPresets[preset]; !ok {
+			return errors.New("unknown preset")
+		}
+		quality.Codec = w.quality.Codec
+	}
+
+	quality, err := w.limitQuality(quality)
+	if err != nil {
+		return err
+	}
+
+	if r.AudioProfile != "" {
+		var profile audio.Profile
+		if r.AudioProfile == AUDIO_PROFILE_CUSTOM {
+			if profile, err = customAudioProfile(r); err != nil {
+				return err
+			}
+		} else {
+			var ok bool
+			if profile, ok = audioProfiles[r.AudioProfile]; !ok {
+				return errors.New("unknown audio profile")
+			}
+		}
+		w.audioProfile = r.AudioProfile
+		w.audioPipe.SetProfile(profile)
+	}
+
+	w.quality = quality
+	w.videoPipe.SetQuality(quality)
+	return w.sendQuality(preset, quality)
+}
+
+// customAudioProfile is the standard profile with the opus settings of the request
+func customAudioProfile(r *SetQualityRequest) (audio.Profile, error) {
+	profile := audio.DefaultProfile()
+
+	profile.FrameDuration = time.Duration(r.AudioFrameDuration * float64(time.Millisecond))
+	if !encoder.IsOpusFrameDuration(profile.FrameDuration) {
+		return profile, errors.New("invalid audio frame duration")
+	}
+
+	if r.AudioComplexity < 0 || r.AudioComplexity > encoder.MAX_OPUS_COMPLEXITY {
+		return profile, errors.New("invalid audio complexity")
+	}
+	profile.Complexity = r.AudioComplexity
+
+	if r.AudioPacketLoss < 0 || r.AudioPacketLoss > 100 {
+		return profile, errors.New("invalid audio packet loss")
+	}
+	profile.PacketLoss = r.AudioPacketLoss
+
+	return profile, nil
+}
+
+// limitQuality enforces the server config, the resolution is scaled down keeping its aspect ratio
+func (w *Worker) limitQuality(q video.Quality) (video.Quality, error) {
+	cfg := w.config.Video
+
+	if !slices.Contains(cfg.Codecs, codecName(q.Codec)) {
+		return q, errors.New("codec not allowed")
+	}
+
+	if q.Width < 0 || q.Height < 0 || (q.Width == 0) != (q.Height == 0) {
+		return q, errors.New("invalid resolution")
+	}
+
+	if q.Width > cfg.MaxWidth || q.Height > cfg.MaxHeight {
+		scale := min(float64(cfg.MaxWidth)/float64(q.Width), float64(cfg.MaxHeight)/float64(q.Height))
+		q.Width = int(float64(q.Width) * scale)
+		q.Height = int(float64(q.Height) * scale)
+	}
+
+	if q.Bitrate <= 0 {
+		q.Bitrate = video.DefaultQuality().Bitrate
+	}
+	q.Bitrate = min(q.Bitrate, cfg.MaxBitrate)
+
+	if q.CRF <= 0 {
+		q.CRF = video.DefaultQuality().CRF
+	}
+	q.CRF = min(max(q.CRF, cfg.MinCRF), MAX_CRF)
+
+	return q, nil
+}
+
+func codecName(codec _video.VideoCodec) string {
+	for name, c := range videoCodecs {
+		if c == codec {
+			return name
+		}
+	}
+	return ""
+}
+
+func (w *Worker) sendQuality(preset string, q video.Quality) error {
+	return w.sendJSON(message.MSG_QUALITY, QualityResponse{
+		Preset:  preset,
+		Width:   q.Width,
+		Height:  q.Height,
+		Bitrate: q.Bitrate,
+		CRF:     q.CRF,
+		Codec:   codecName(q.Codec),
+
+		AudioProfile: w.audioProfile,
+	})
+}
@@ -0,0 +1,139 @@
+package worker
+
+import (
+	"cloud_gaming/pkg/emulator"
+	"cloud_gaming/pkg/libretro"
+	"cloud_gaming/pkg/log"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// at most one rumble update per port is sent every RUMBLE_INTERVAL
+	RUMBLE_INTERVAL = 50 * time.Millisecond
+)
+
+type (
+	rumbleData struct {
+		User   uint   `json:"user"`
+		Strong uint16 `json:"strong"` // 0 - 0xffff
+		Weak   uint16 `json:"weak"`   // 0 - 0xffff
+	}
+
+	// rumbleState collects strength changes from the core between two sends
+	rumbleState struct {
+		mu    sync.Mutex
+		ports [emulator.MAX_PLAYERS]rumbleData
+		dirty [emulator.MAX_PLAYERS]bool
+
+		done chan struct{}
+	}
+)
+
+// Set is called by the core from the emulator thread
+func (r *rumbleState) Set(port uint, effect uint32, strength uint16) bool {
+	if port >= emulator.MAX_PLAYERS {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := &r.ports[port]
+	switch effect {
+	case libretro.RumbleStrong:
+		r.dirty[port] = r.dirty[port] || state.Strong != strength
+		state.Strong = strength
+	case libretro.RumbleWeak:
+		r.dirty[port] = r.dirty[port] || state.Weak != strength
+		state.Weak = strength
+	default:
+		return false
+	}
+
+	return true
+}
+
+// drain returns the ports changed since the previous call
+func (r *rumbleState) drain() []rumbleData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var res []rumbleData
+	for port := range r.ports {
+		if !r.dirty[port] {
+			continue
+		}
+
+		data := r.ports[port]
+		data.User = uint(port)
+		res = append(res, data)
+		r.dirty[port] = false
+	}
+
+	return res
+}
+
+func (r *rumbleState) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ports = [emulator.MAX_PLAYERS]rumbleData{}
+	r.dirty = [emulator.MAX_PLAYERS]bool{}
+}
+
+func (w *Worker) rumbleCallback(port uint, effect uint32, strength uint16) bool {
+	return w.rumble.Set(port, effect, strength)
+}
+
+func (w *Worker) startRumble() {
+	w.rumble.reset()
+	w.rumble.done = make(chan struct{})
+	go w.sendRumbleLoop(w.rumble.done)
+}
+
+func (w *Worker) stopRumble() {
+	if w.rumble.done == nil {
+		return
+	}
+
+	close(w.rumble.done)
+	w.rumble.done = nil
+}
+
+func (w *Worker) sendRumbleLoop(done chan struct{}) {
+	ticker := time.NewTicker(RUMBLE_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			for _, data := range w.rumble.drain() {
+				w.sendRumble(data)
+			}
+		}
+	}
+}
+
+func (w *Worker) sendRumble(data rumbleData) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Error("marshal rumble data failed", zap.Error(err))
+		return
+	}
+
+	// only the peer playing the port feels it
+	p := w.peers.Get(w.ports.Owner(data.User))
+	if p == nil {
+		return
+	}
+
+	if err := p.SendRumble(payload); err != nil {
+		log.Debug("send rumble failed", zap.Error(err))
+	}
+}
@@ -1,21 +1,63 @@
 package worker
 
 import (
+	_video "cloud_gaming/pkg/ffmpeg/video"
+	"cloud_gaming/pkg/log"
+	"cloud_gaming/pkg/message"
 	"cloud_gaming/pkg/pipeline/video"
 	"time"
 
 	"github.com/pion/webrtc/v3/pkg/media"
+	"go.uber.org/zap"
 )
 
+type (
+	VideoEncoderResponse struct {
+		Encoder string `json:"encoder"` // e.g: "libx264"
+		Codec   string `json:"codec"`
+	}
+)
+
+var scaleModes = map[string]video.ScaleMode{
+	"":        video.ScaleFit,
+	"fit":     video.ScaleFit,
+	"stretch": video.ScaleStretch,
+	"integer": video.ScaleInteger,
+}
+
 func (w *Worker) sendVideoFrame(vidFrame *video.VideoFrame) {
-	w.peerConn.SendVideoFrame(media.Sample{
+	updateLatency(&w.avSync.videoLatency, w.emulator.MediaTime()-vidFrame.PTS)
+
+	nominal := time.Duration(vidFrame.Duration * float64(time.Millisecond))
+	w.avSync.videoLine.Push(media.Sample{
 		Data:     vidFrame.Data,
-		Duration: time.Duration(vidFrame.Duration) * time.Millisecond,
+		Duration: w.avSync.video.Duration(vidFrame.PTS, nominal),
 		Metadata: map[string]interface{}{
 			"Codec":  vidFrame.Codec,
 			"Format": vidFrame.Format,
 			"Width":  vidFrame.Width,
 			"Height": vidFrame.Height,
 		},
-	})
+	}, w.avSync.VideoDelay())
+}
+
+// writeVideoSample runs on the video delay line
+func (w *Worker) writeVideoSample(sample media.Sample) {
+	for _, p := range w.peers.All() {
+		p.SendVideoFrame(sample)
+	}
+}
+
+// sendVideoGeometry is called from the emulator thread whenever the placement of the game screen changes
+func (w *Worker) sendVideoGeometry(geometry video.OutputGeometry) {
+	if err := w.sendJSON(message.MSG_VIDEO_GEOMETRY, geometry); err != nil {
+		log.Error("send video geometry failed", zap.Error(err))
+	}
+}
+
+// sendVideoEncoder is called from the emulator thread when another encoder implementation is used
+func (w *Worker) sendVideoEncoder(name string, codec _video.VideoCodec) {
+	if err := w.sendJSON(message.MSG_VIDEO_ENCODER, VideoEncoderResponse{Encoder: name, Codec: codecName(codec)}); err != nil {
+		log.Error("send video encoder failed", zap.Error(err))
+	}
 }
@@ -1,6 +1,7 @@
 package worker
 
 import (
+	"cloud_gaming/pkg/config"
 	"cloud_gaming/pkg/emulator"
 	"cloud_gaming/pkg/log"
 	"cloud_gaming/pkg/message"
@@ -12,6 +13,7 @@ import (
 	_websocket "cloud_gaming/pkg/websocket"
 
 	"encoding/json"
+	"errors"
 	"net/url"
 
 	"github.com/gorilla/websocket"
@@ -23,26 +25,66 @@ type (
 	Worker struct {
 		webrtcFactory   *_webrtc.Factory
 		coordinatorConn *_websocket.Conn
-		peerConn        *_webrtc.PeerConnection
+		peers           peerSet
 		emulator        *emulator.Emulator
 		videoPipe       *video.VideoPipeline
 		audioPipe       *audio.AudioPipeline
 		storage         *storage.Storage
+		config          config.WorkerConfig
+		// quality is the last one set, its codec is the one negotiated with the client
+		quality video.Quality
+		// audioProfile is the name of the profile the audio is encoded with
+		audioProfile string
+
+		inputDescriptors inputDescriptors
+		controllerInfo   controllerInfo
+		ports            portAssignment
+		gamepadSeq       gamepadSequence
+		rumble           rumbleState
+		avSync           avSync
+
+		// requests and disconnected peers are queued for requestHandler
+		requests     chan *message.RequestMsg
+		disconnected chan *peer
+
+		// game is the name of the running game, empty if no game is running
+		game string
+		// movieName is the name of the movie being recorded or played back
+		movieName string
 	}
 )
 
 func New() (*Worker, error) {
 	var err error
 	w := &Worker{
-		emulator: emulator.New(),
-		storage:  storage.New(),
+		emulator:     emulator.New(),
+		requests:     make(chan *message.RequestMsg),
+		disconnected: make(chan *peer, MAX_PENDING_DISCONNECTS),
+		storage:      storage.New(),
+		quality:      video.DefaultQuality(),
+		audioProfile: AUDIO_PROFILE_STANDARD,
 	}
 
-	w.videoPipe, err = video.NewVideoPipeline(w.sendVideoFrame)
+	w.config, err = config.LoadWorkerConfig(config.WORKER_CONFIG_PATH)
 	if err != nil {
 		return nil, err
 	}
+
+	pacing, ok := pacings[w.config.Emulator.Pacing]
+	if !ok {
+		return nil, errors.New("unknown emulator pacing")
+	}
+	w.emulator.SetPacing(pacing)
+
+	w.videoPipe, err = video.NewVideoPipeline(w.sendVideoFrame, w.sendVideoGeometry, w.sendVideoEncoder)
+	if err != nil {
+		return nil, err
+	}
+	w.videoPipe.SetEncoders(w.config.Video.Encoders)
+	w.videoPipe.SetMaxSize(w.config.Video.MaxWidth, w.config.Video.MaxHeight)
 	w.audioPipe = audio.NewAudioPipeline(w.sendAudioPacket)
+	w.avSync.videoLine.start(w.writeVideoSample, nil)
+	w.avSync.audioLine.start(w.writeAudioSample, &w.avSync.audioBuffers)
 	return w, nil
 }
 
@@ -50,6 +92,7 @@ func (w *Worker) Run() {
 	w.initWebSocketConnToCoordinator()
 	w.initWebrtcFactory()
 
+	go w.readRequests()
 	go w.requestHandler()
 }
 
@@ -68,132 +111,340 @@ func (w *Worker) initWebSocketConnToCoordinator() {
 	w.coordinatorConn = _websocket.New(c)
 }
 
-func (w *Worker) requestHandler() {
-	var err error
-
+// readRequests hands the requests of the coordinator to requestHandler
+func (w *Worker) readRequests() {
 	conn := w.coordinatorConn
-	defer conn.Close()
-
-	w.peerConn, err = w.resetWebRTC()
-	if err != nil {
-		log.Fatal("create webrtc connection failed", zap.Error(err))
-	}
+	defer close(w.requests)
 
 	for {
 		_, data, err := conn.ReadMessage()
 		if err != nil {
 			conn.Close()
 			log.Debug("worker web socket closed")
-			break
+			return
 		}
 
 		msg := &message.RequestMsg{}
 		if err := json.Unmarshal(data, msg); err != nil {
-			w.sendError("unknown", "unmarshal request message failed")
+			w.sendError(ALL_PEERS, "unknown", "unmarshal request message failed")
 			continue
 		}
 
-		switch msg.Label {
-		case message.MSG_WEBRTC_INIT:
-			localSD, err := w.peerConn.CreateOffer(nil)
-			if err != nil {
-				log.Error("create local session description failed", zap.Error(err))
-				w.sendError(msg.Label, "create local session description failed")
-				continue
-			}
+		w.requests <- msg
+	}
+}
 
-			err = w.peerConn.SetLocalDescription(localSD)
-			if err != nil {
-				log.Error("set local description failed", zap.Error(err))
-				w.sendError(msg.Label, "set local description failed")
-				continue
-			}
+// requestHandler is the only goroutine changing the session, games are started and stopped
+// and peers are added and removed here, including the ones whose connection dropped
+func (w *Worker) requestHandler() {
+	defer w.coordinatorConn.Close()
 
-			payload, err := json.Marshal(localSD)
-			if err != nil {
-				log.Error("marshal local session description failed", zap.Error(err))
-				w.sendError(msg.Label, "marshal local session description failed")
-				continue
+	for {
+		select {
+		case p := <-w.disconnected:
+			w.removePeer(p)
+		case msg, ok := <-w.requests:
+			if !ok {
+				return
 			}
+			w.handleRequest(msg)
+		}
+	}
+}
 
-			res := &message.ResponseMsg{
-				Label:   message.MSG_WEBRTC_OFFER,
-				Payload: payload,
-				Error:   nil,
-			}
+func (w *Worker) handleRequest(msg *message.RequestMsg) {
+	var err error
 
-			w.coordinatorConn.WriteJSON(res)
-		case message.MSG_WEBRTC_ANSWER:
-			var remoteSD = &webrtc.SessionDescription{}
-			err := json.Unmarshal(msg.Payload, remoteSD)
-			if err != nil {
-				log.Error("unmarshal session description offer failed", zap.Error(err))
-				w.sendError(msg.Label, "unmarshal session description offer failed")
-				continue
-			}
-			w.peerConn.SetRemoteDescription(*remoteSD)
-
-		case message.MSG_WEBRTC_ICE_CANDIDATE:
-			var candidate = &webrtc.ICECandidateInit{}
-			err := json.Unmarshal(msg.Payload, candidate)
-			if err != nil {
-				log.Error("unmarshal ice candidate failed", zap.Error(err))
-				w.sendError(msg.Label, "unmarshal ice candidate failed")
-				continue
-			}
-			w.peerConn.AddICECandidate(*candidate)
-
-		case message.MSG_START_GAME:
-			r := &StartGameRequest{}
-			err = json.Unmarshal(msg.Payload, r)
-			if err != nil {
-				log.Error("unmarshal game request failed", zap.Error(err))
-				w.sendError(msg.Label, "unmarshal game request failed")
-				continue
+	switch msg.Label {
+	case message.MSG_WEBRTC_INIT:
+		r := &WebRTCInitRequest{}
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, r); err != nil {
+				log.Error("unmarshal webrtc init request failed", zap.Error(err))
+				w.sendError(msg.PeerID, msg.Label, "unmarshal webrtc init request failed")
+				return
 			}
+		}
+
+		p, err := w.newPeer(msg.PeerID)
+		if err != nil {
+			log.Error("create webrtc connection failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "create webrtc connection failed")
+			return
+		}
+		p.user = msg.User
+
+		if err := w.negotiateVideoCodec(p, r); err != nil {
+			log.Error("negotiate video codec failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "negotiate video codec failed")
+			w.removePeer(p)
+			return
+		}
+
+		localSD, err := p.CreateOffer(nil)
+		if err != nil {
+			log.Error("create local session description failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "create local session description failed")
+			return
+		}
+
+		err = p.SetLocalDescription(localSD)
+		if err != nil {
+			log.Error("set local description failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "set local description failed")
+			return
+		}
+
+		payload, err := json.Marshal(localSD)
+		if err != nil {
+			log.Error("marshal local session description failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "marshal local session description failed")
+			return
+		}
 
-			err = w.startEmulator(r)
-			if err != nil {
-				log.Error("start emulator failed", zap.Error(err))
-				w.sendError(msg.Label, "start emulator failed")
-				continue
+		res := &message.ResponseMsg{
+			Label:   message.MSG_WEBRTC_OFFER,
+			Payload: payload,
+			Error:   nil,
+			PeerID:  msg.PeerID,
+		}
+
+		w.coordinatorConn.WriteJSON(res)
+
+		// joined a running game
+		if w.game != "" {
+			w.ensurePort(msg.PeerID)
+			if err := w.sendControllerInfo(); err != nil {
+				log.Error("send controller info failed", zap.Error(err))
 			}
+		}
+	case message.MSG_WEBRTC_ANSWER:
+		var remoteSD = &webrtc.SessionDescription{}
+		err := json.Unmarshal(msg.Payload, remoteSD)
+		if err != nil {
+			log.Error("unmarshal session description offer failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal session description offer failed")
+			return
+		}
+
+		p := w.peers.Get(msg.PeerID)
+		if p == nil {
+			w.sendError(msg.PeerID, msg.Label, "webrtc connection is not initialized")
+			return
+		}
+		p.SetRemoteDescription(*remoteSD)
+
+	case message.MSG_WEBRTC_ICE_CANDIDATE:
+		var candidate = &webrtc.ICECandidateInit{}
+		err := json.Unmarshal(msg.Payload, candidate)
+		if err != nil {
+			log.Error("unmarshal ice candidate failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal ice candidate failed")
+			return
+		}
+
+		p := w.peers.Get(msg.PeerID)
+		if p == nil {
+			w.sendError(msg.PeerID, msg.Label, "webrtc connection is not initialized")
+			return
+		}
+		p.AddICECandidate(*candidate)
+
+	case message.MSG_PEER_LEFT:
+		w.removePeerByID(msg.PeerID)
+
+	case message.MSG_START_GAME:
+		r := &StartGameRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal game request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal game request failed")
+			return
+		}
+
+		err = w.startEmulator(r)
+		if err != nil {
+			log.Error("start emulator failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "start emulator failed")
+			return
+		}
+
+	case message.MSG_STOP_GAME:
+		w.stopEmulator()
+
+	case message.MSG_GET_INPUT_PROFILE:
+		r := &GetInputProfileRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal get input profile request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal get input profile request failed")
+			return
+		}
 
-		case message.MSG_STOP_GAME:
-			w.stopEmulator()
+		err = w.getInputProfile(msg.PeerID, r)
+		if err != nil {
+			log.Error("get input profile failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "get input profile failed")
+			return
+		}
+
+	case message.MSG_UPDATE_INPUT_PROFILE:
+		r := &UpdateInputProfileRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal update input profile request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal update input profile request failed")
+			return
+		}
+
+		err = w.updateInputProfile(msg.PeerID, msg.Owner, r)
+		if err != nil {
+			log.Error("update input profile failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "update input profile failed")
+			return
+		}
+
+	case message.MSG_ASSIGN_PORT:
+		r := &AssignPortRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal assign port request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal assign port request failed")
+			return
+		}
+
+		err = w.assignPort(msg.PeerID, r)
+		if err != nil {
+			log.Error("assign port failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "assign port failed")
+			return
+		}
+
+	case message.MSG_RELEASE_PORT:
+		r := &ReleasePortRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal release port request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal release port request failed")
+			return
+		}
+
+		err = w.releasePort(msg.PeerID, r)
+		if err != nil {
+			log.Error("release port failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "release port failed")
+			return
+		}
+
+	case message.MSG_SET_CONTROLLER_DEVICE:
+		r := &SetControllerDeviceRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal set controller device request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal set controller device request failed")
+			return
+		}
+
+		err = w.setControllerDevice(msg.PeerID, r)
+		if err != nil {
+			log.Error("set controller device failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "set controller device failed")
+			return
+		}
+
+	case message.MSG_START_RECORDING:
+		r := &StartRecordingRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal start recording request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal start recording request failed")
+			return
+		}
+
+		err = w.startRecording(r)
+		if err != nil {
+			log.Error("start recording failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "start recording failed")
+			return
+		}
+
+	case message.MSG_STOP_RECORDING:
+		err = w.stopRecording()
+		if err != nil {
+			log.Error("stop recording failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "stop recording failed")
+			return
+		}
+
+	case message.MSG_START_PLAYBACK:
+		r := &StartPlaybackRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal start playback request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal start playback request failed")
+			return
+		}
+
+		err = w.startPlayback(r)
+		if err != nil {
+			log.Error("start playback failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "start playback failed")
+			return
+		}
+
+	case message.MSG_STOP_PLAYBACK:
+		err = w.stopPlayback()
+		if err != nil {
+			log.Error("stop playback failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "stop playback failed")
+			return
+		}
+
+	case message.MSG_SET_QUALITY:
+		r := &SetQualityRequest{}
+		err = json.Unmarshal(msg.Payload, r)
+		if err != nil {
+			log.Error("unmarshal set quality request failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "unmarshal set quality request failed")
+			return
+		}
+
+		err = w.setQuality(r)
+		if err != nil {
+			log.Error("set quality failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "set quality failed")
+			return
+		}
+
+	case message.MSG_GET_FRAME_STATS:
+		err = w.sendFrameStats(msg.PeerID)
+		if err != nil {
+			log.Error("send frame stats failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "send frame stats failed")
+			return
 		}
 
+	case message.MSG_GET_AV_SYNC:
+		err = w.sendAVSync(msg.PeerID)
+		if err != nil {
+			log.Error("send av sync failed", zap.Error(err))
+			w.sendError(msg.PeerID, msg.Label, "send av sync failed")
+			return
+		}
 	}
 }
 
-func (w *Worker) sendError(label message.MsgType, text string) {
+func (w *Worker) sendError(peer string, label message.MsgType, text string) {
 	resp := message.NewErrorMsg(label, text)
+	resp.PeerID = peer
 	w.coordinatorConn.WriteJSON(resp)
 }
 
 func (w *Worker) initWebrtcFactory() {
-	factory, err := _webrtc.NewFactory()
+	factory, err := _webrtc.NewFactory(w.config.Video.MaxBitrate)
 	if err != nil {
 		log.Fatal("init webrtc factory failed", zap.Error(err))
 	}
 
 	w.webrtcFactory = factory
 }
-
-func (w *Worker) callbackWebRTCDisconnected() {
-	var err error
-
-	w.stopEmulator()
-	w.peerConn, err = w.resetWebRTC()
-	if err != nil {
-		log.Fatal("re-create webrtc connection failed", zap.Error(err))
-	}
-}
-
-func (w *Worker) resetWebRTC() (*_webrtc.PeerConnection, error) {
-	if w.peerConn != nil {
-		w.peerConn.Close()
-	}
-
-	return _webrtc.NewPeerConnection(w.coordinatorConn, w.webrtcFactory, w.callbackWebRTCDisconnected, w.handleKeyboardChannel, w.handleMouseChannel)
-}